@@ -2,30 +2,54 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"log/syslog"
+	"math"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/coreos/go-systemd/v22/dbus"
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/proxy"
 
 	_ "github.com/fernvenue/wg-ddns/docs"
 )
 
 const Version = "1.2"
 
+//go:embed dashboard
+var dashboardFS embed.FS
+
 type LogLevel int
 
 const (
@@ -42,21 +66,139 @@ var logLevelNames = map[LogLevel]string{
 	ERROR: "ERROR",
 }
 
+var logLevelColors = map[LogLevel]string{
+	DEBUG: "\033[90m",
+	INFO:  "\033[32m",
+	WARN:  "\033[33m",
+	ERROR: "\033[31m",
+}
+
+const colorReset = "\033[0m"
+
+const defaultTimestampFormat = "2006/01/02 15:04:05"
+
 type Logger struct {
+	level           LogLevel
+	timestampFormat string
+	color           bool
+	syslog          *syslog.Writer
+	recent          *LogBuffer
+}
+
+type LogEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+
 	level LogLevel
 }
 
+// LogBuffer keeps the most recent log entries in a fixed-size ring.
+type LogBuffer struct {
+	mu      sync.Mutex
+	entries []LogEntry
+	next    int
+	full    bool
+}
+
+func newLogBuffer(size int) *LogBuffer {
+	return &LogBuffer{entries: make([]LogEntry, size)}
+}
+
+func (b *LogBuffer) add(entry LogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.entries[b.next] = entry
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Recent returns up to limit of the newest entries at or above minLevel,
+// oldest first.
+func (b *LogBuffer) Recent(limit int, minLevel LogLevel) []LogEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ordered := b.entries[:b.next]
+	if b.full {
+		ordered = append(append([]LogEntry{}, b.entries[b.next:]...), b.entries[:b.next]...)
+	}
+
+	result := make([]LogEntry, 0, limit)
+	for i := len(ordered) - 1; i >= 0 && len(result) < limit; i-- {
+		if ordered[i].level >= minLevel {
+			result = append(result, ordered[i])
+		}
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+// isTerminal reports whether f is attached to a character device, which is
+// how an interactive terminal shows up on every supported platform.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func (l *Logger) formatTimestamp(t time.Time) string {
+	switch l.timestampFormat {
+	case "":
+		return t.Format(defaultTimestampFormat)
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	default:
+		return t.Format(l.timestampFormat)
+	}
+}
+
 func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
 	if level < l.level {
 		return
 	}
 
-	timestamp := time.Now().Format("2006/01/02 15:04:05")
-	levelName := logLevelNames[level]
+	now := time.Now()
 	message := fmt.Sprintf(format, args...)
+	if l.recent != nil {
+		l.recent.add(LogEntry{Time: now, Level: logLevelNames[level], Message: message, level: level})
+	}
+
+	if l.syslog != nil {
+		l.writeSyslog(level, message)
+		return
+	}
+
+	timestamp := l.formatTimestamp(now)
+	levelName := logLevelNames[level]
+	if l.color {
+		levelName = logLevelColors[level] + levelName + colorReset
+	}
 	fmt.Printf("%s [%s] %s\n", timestamp, levelName, message)
 }
 
+// writeSyslog sends message with the syslog severity matching level. Syslog
+// adds its own timestamp, so none is included.
+func (l *Logger) writeSyslog(level LogLevel, message string) {
+	switch level {
+	case DEBUG:
+		l.syslog.Debug(message)
+	case INFO:
+		l.syslog.Info(message)
+	case WARN:
+		l.syslog.Warning(message)
+	default:
+		l.syslog.Err(message)
+	}
+}
+
 func (l *Logger) Debug(format string, args ...interface{}) {
 	l.log(DEBUG, format, args...)
 }
@@ -75,6 +217,29 @@ func (l *Logger) Error(format string, args ...interface{}) {
 
 var logger *Logger
 
+func parseTimestampFormat(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "":
+		return defaultTimestampFormat, nil
+	case "rfc3339":
+		return time.RFC3339, nil
+	case "rfc3339nano":
+		return time.RFC3339Nano, nil
+	case "unix":
+		return "unix", nil
+	}
+
+	reference := time.Date(2001, time.February, 3, 4, 5, 6, 7000000, time.UTC)
+	formatted := reference.Format(value)
+	if formatted == value {
+		return "", fmt.Errorf("layout '%s' contains no time elements", value)
+	}
+	if _, err := time.Parse(value, formatted); err != nil {
+		return "", fmt.Errorf("invalid layout '%s': %w", value, err)
+	}
+	return value, nil
+}
+
 func parseLogLevel(level string) LogLevel {
 	switch strings.ToLower(level) {
 	case "debug":
@@ -90,255 +255,2704 @@ func parseLogLevel(level string) LogLevel {
 	}
 }
 
-type Config struct {
-	Interface string
-	Endpoint  string
-	Hostname  string
-	LastIP    net.IP
+type InterfaceFilter struct {
+	include []string
+	exclude []string
 }
 
-type DDNSMonitor struct {
-	configs         []Config
-	conn            *dbus.Conn
-	singleInterface string
-	apiEnabled      bool
-	listenAddress   string
-	listenPort      string
-	apiKey          string
-	httpServer      *http.Server
-	checkInterval   time.Duration
-}
+func parseInterfaceFilter(value string) (*InterfaceFilter, error) {
+	filter := &InterfaceFilter{}
 
-type RestartRequest struct {
-	Interface string `json:"interface" binding:"required"`
-}
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
 
-type RestartResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+		exclude := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		if pattern == "" {
+			return nil, fmt.Errorf("empty exclusion pattern")
+		}
+
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+
+		if exclude {
+			filter.exclude = append(filter.exclude, pattern)
+		} else {
+			filter.include = append(filter.include, pattern)
+		}
+	}
+
+	if len(filter.include) == 0 && len(filter.exclude) == 0 {
+		return nil, fmt.Errorf("no patterns specified")
+	}
+
+	return filter, nil
 }
 
-type Args struct {
-	singleInterface string
-	listenAddress   string
-	listenPort      string
-	apiKey          string
-	logLevel        string
-	checkInterval   string
-	help            bool
-	version         bool
-	checkOnly       bool
+func (f *InterfaceFilter) String() string {
+	patterns := append([]string{}, f.include...)
+	for _, pattern := range f.exclude {
+		patterns = append(patterns, "!"+pattern)
+	}
+	return strings.Join(patterns, ",")
 }
 
-func parseArgs() *Args {
-	args := &Args{}
+func (f *InterfaceFilter) Match(name string) bool {
+	if f == nil {
+		return true
+	}
 
-	args.singleInterface = os.Getenv("WG_DDNS_SINGLE_INTERFACE")
-	args.listenAddress = os.Getenv("WG_DDNS_LISTEN_ADDRESS")
-	args.listenPort = os.Getenv("WG_DDNS_LISTEN_PORT")
-	args.apiKey = os.Getenv("WG_DDNS_API_KEY")
-	args.logLevel = os.Getenv("WG_DDNS_LOG_LEVEL")
-	args.checkInterval = os.Getenv("WG_DDNS_CHECK_INTERVAL")
+	for _, pattern := range f.exclude {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return false
+		}
+	}
 
-	for i := 1; i < len(os.Args); i++ {
-		arg := os.Args[i]
+	if len(f.include) == 0 {
+		return true
+	}
 
-		if !strings.HasPrefix(arg, "--") {
-			if arg == "-h" || arg == "-help" {
-				args.help = true
-				continue
-			}
-			fmt.Fprintf(os.Stderr, "Error: Invalid argument format '%s'. Only double-dash (--) options are supported.\n", arg)
-			os.Exit(1)
+	for _, pattern := range f.include {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
 		}
+	}
 
-		if arg == "--help" {
-			args.help = true
+	return false
+}
+
+// MonitorHostnames holds the hostnames of --monitor-hostname, which IP-literal
+// endpoints without a monitor= annotation are tracked through. An entry for
+// an interface takes precedence over the one for all interfaces.
+type MonitorHostnames struct {
+	fallback     string
+	perInterface map[string]string
+}
+
+func parseMonitorHostnames(value string) (*MonitorHostnames, error) {
+	hostnames := &MonitorHostnames{perInterface: make(map[string]string)}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
 			continue
 		}
 
-		if arg == "--version" {
-			args.version = true
-			continue
+		interfaceName, hostname, scoped := strings.Cut(entry, "=")
+		if !scoped {
+			interfaceName, hostname = "", entry
 		}
+		interfaceName = strings.TrimSpace(interfaceName)
+		hostname = strings.TrimSpace(hostname)
 
-		if arg == "--check-only" {
-			args.checkOnly = true
+		if hostname == "" || strings.ContainsAny(hostname, " \t") || isIPLiteral(hostname) {
+			return nil, fmt.Errorf("invalid hostname '%s'", hostname)
+		}
+		if !scoped {
+			if hostnames.fallback != "" {
+				return nil, fmt.Errorf("more than one hostname for all interfaces")
+			}
+			hostnames.fallback = hostname
 			continue
 		}
+		if err := validateInterfaceName(interfaceName); err != nil {
+			return nil, err
+		}
+		if _, exists := hostnames.perInterface[interfaceName]; exists {
+			return nil, fmt.Errorf("more than one hostname for interface '%s'", interfaceName)
+		}
+		hostnames.perInterface[interfaceName] = hostname
+	}
 
-		parts := strings.SplitN(arg, "=", 2)
-		var key, value string
+	if hostnames.fallback == "" && len(hostnames.perInterface) == 0 {
+		return nil, fmt.Errorf("no hostnames specified")
+	}
 
-		if len(parts) == 2 {
-			key = parts[0]
-			value = parts[1]
-		} else {
-			key = arg
-			if i+1 < len(os.Args) && !strings.HasPrefix(os.Args[i+1], "--") {
-				i++
-				value = os.Args[i]
-			}
-		}
+	return hostnames, nil
+}
 
-		switch key {
-		case "--single-interface":
-			args.singleInterface = value
-		case "--listen-address":
-			args.listenAddress = value
-		case "--listen-port":
-			args.listenPort = value
-		case "--api-key":
-			args.apiKey = value
-		case "--log-level":
-			args.logLevel = value
-		case "--check-interval":
-			args.checkInterval = value
-		default:
-			fmt.Fprintf(os.Stderr, "Error: Unknown option '%s'\n", key)
-			os.Exit(1)
-		}
+// For returns the hostname monitored for the IP-literal endpoints of
+// interfaceName, or "" when there is none.
+func (h *MonitorHostnames) For(interfaceName string) string {
+	if h == nil {
+		return ""
+	}
+	if hostname, ok := h.perInterface[interfaceName]; ok {
+		return hostname
 	}
+	return h.fallback
+}
 
-	return args
+type Config struct {
+	Interface           string
+	Endpoint            string
+	Hostname            string
+	Port                string
+	PortOverride        string
+	Family              string
+	StaticIP            net.IP
+	PublicKey           string
+	LastIP              net.IP
+	LastIPv4            net.IP
+	LastIPv6            net.IP
+	BackupHostname      string
+	BackupPort          string
+	BackupIP            net.IP
+	UsingBackup         bool
+	ConsecutiveFailures int
+	FailActionTaken     bool
+	ChangeCount         int
+	LastChangeAt        time.Time
+	LastCheckAt         time.Time
+	RecentIPs           []string
+	Interval            time.Duration
+	NextCheckAt         time.Time
+	ResolvedIP          net.IP
+	Addresses           []net.IP
+	FamilyReason        string
+	Fingerprint         string
+	Disabled            bool
+	Labels              map[string]string
 }
 
-func printUsage() {
-	fmt.Printf("Usage: %s [OPTIONS]\n\n", os.Args[0])
-	fmt.Println("OPTIONS:")
-	fmt.Println("  --single-interface string    Monitor only the specified WireGuard interface")
-	fmt.Println("  --listen-address string      HTTP API listen address")
-	fmt.Println("  --listen-port string         HTTP API listen port")
-	fmt.Println("  --api-key string             API key for authentication")
-	fmt.Println("  --log-level string           Log level: debug, info, warn, error (default: info)")
-	fmt.Println("  --check-interval string      DNS check interval (e.g., 10s, 1m, 5m) (default: 10s)")
-	fmt.Println("  --check-only                 Check active WireGuard interfaces and exit")
-	fmt.Println("  --version                    Show version information")
-	fmt.Println("  --help                       Show this help message")
-	fmt.Println("")
-	fmt.Println("ENVIRONMENT VARIABLES:")
-	fmt.Println("  WG_DDNS_SINGLE_INTERFACE     Same as --single-interface")
-	fmt.Println("  WG_DDNS_LISTEN_ADDRESS       Same as --listen-address")
-	fmt.Println("  WG_DDNS_LISTEN_PORT          Same as --listen-port")
-	fmt.Println("  WG_DDNS_API_KEY              Same as --api-key")
-	fmt.Println("  WG_DDNS_LOG_LEVEL            Same as --log-level")
-	fmt.Println("  WG_DDNS_CHECK_INTERVAL       Same as --check-interval")
-	fmt.Println("")
-	fmt.Println("NOTES:")
-	fmt.Println("  - All three API options (--listen-address, --listen-port, --api-key) must be provided together to enable API functionality")
-	fmt.Println("  - Command line options override environment variables")
-	fmt.Println("  - Use double-dash (--) format for all options")
+func (c *Config) CurrentEndpoint() string {
+	if c.UsingBackup && c.BackupIP != nil {
+		return net.JoinHostPort(c.BackupIP.String(), c.BackupPort)
+	}
+	if c.LastIP == nil {
+		return ""
+	}
+	return net.JoinHostPort(normalizeIP(c.LastIP).String(), c.EndpointPort())
 }
 
-func printVersion() {
-	fmt.Printf("wg-ddns version %s\n", Version)
+// EndpointPort returns the port endpoint updates use: the override set
+// through the API if any, otherwise the port from the configuration.
+func (c *Config) EndpointPort() string {
+	if c.PortOverride != "" {
+		return c.PortOverride
+	}
+	return c.Port
 }
 
-func performCheckOnly(singleInterface string) {
-	conn, err := dbus.NewWithContext(context.Background())
-	if err != nil {
-		fmt.Printf("Error: Failed to connect to systemd: %v\n", err)
-		os.Exit(1)
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
 	}
-	defer conn.Close()
+	return normalizeIP(ip).String()
+}
 
-	var configs []Config
-	
-	if singleInterface != "" {
-		configPath := filepath.Join("/etc/wireguard", singleInterface+".conf")
-		if err := parseWireGuardConfigForCheck(singleInterface, configPath, &configs); err != nil {
-			fmt.Printf("Error: Failed to parse config for %s: %v\n", singleInterface, err)
-			os.Exit(1)
+func normalizeIP(ip net.IP) net.IP {
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+const lookupTimeout = 10 * time.Second
+
+func parseDNSServers(value string) ([]string, error) {
+	var servers []string
+
+	for _, server := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	}) {
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			host, port = strings.Trim(server, "[]"), "53"
 		}
-		fmt.Printf("Checking single interface: %s\n", singleInterface)
-	} else {
-		if err := discoverWireGuardConfigsForCheck(conn, &configs); err != nil {
-			fmt.Printf("Error: Failed to discover WireGuard interfaces: %v\n", err)
-			os.Exit(1)
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid DNS server '%s': must be an IP address with optional port", server)
 		}
-		fmt.Printf("Scanning all active WireGuard interfaces...\n")
+		servers = append(servers, net.JoinHostPort(host, port))
 	}
 
-	if len(configs) == 0 {
-		fmt.Println("No active WireGuard interfaces with domain endpoints found.")
-		return
-	}
+	return servers, nil
+}
 
-	fmt.Printf("\nFound %d active WireGuard interface(s) with domain endpoints:\n\n", len(configs))
-	
-	for i, config := range configs {
-		fmt.Printf("%d. Interface: %s\n", i+1, config.Interface)
-		fmt.Printf("   Endpoint: %s\n", config.Endpoint)
-		fmt.Printf("   Hostname: %s\n", config.Hostname)
-		if config.LastIP != nil {
-			fmt.Printf("   Current IP: %s\n", config.LastIP)
-		} else {
-			fmt.Printf("   Current IP: (failed to resolve)\n")
+// checkDNSSECServers refuses servers whose answers --dnssec cannot trust.
+// Answers are not validated locally, only their AD bit is checked, and that
+// bit is only as trustworthy as the path to the resolver that set it, so the
+// validating resolver has to run on this host.
+func checkDNSSECServers(servers []string) error {
+	for _, server := range servers {
+		host, _, err := net.SplitHostPort(server)
+		if err != nil {
+			host = server
+		}
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return fmt.Errorf("--dnssec only trusts validating resolvers on a loopback address, %s is not one", server)
 		}
-		fmt.Println()
 	}
+	return nil
 }
 
-func discoverWireGuardConfigsForCheck(conn *dbus.Conn, configs *[]Config) error {
-	units, err := conn.ListUnitsContext(context.Background())
+func readDNSServersFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to list systemd units: %w", err)
+		return nil, fmt.Errorf("failed to read DNS servers file %s: %w", path, err)
 	}
 
-	for _, unit := range units {
-		if strings.HasPrefix(unit.Name, "wg-quick@") && strings.HasSuffix(unit.Name, ".service") && unit.ActiveState == "active" {
-			interfaceName := strings.TrimPrefix(unit.Name, "wg-quick@")
-			interfaceName = strings.TrimSuffix(interfaceName, ".service")
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		lines = append(lines, line)
+	}
 
-			configPath := filepath.Join("/etc/wireguard", interfaceName+".conf")
-			if err := parseWireGuardConfigForCheck(interfaceName, configPath, configs); err != nil {
-				continue
+	return parseDNSServers(strings.Join(lines, "\n"))
+}
+
+func parseDNSProxy(value string) (proxy.ContextDialer, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS proxy '%s': %w", value, err)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("invalid DNS proxy '%s': only socks5:// is supported", value)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid DNS proxy '%s': missing host", value)
+	}
+
+	dialer, err := proxy.FromURL(u, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS proxy '%s': %w", value, err)
+	}
+
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("invalid DNS proxy '%s': dialer does not support contexts", value)
+	}
+	return contextDialer, nil
+}
+
+// ResolveSource holds the local addresses DNS queries are sent from, one per
+// family so that queries to IPv4 and IPv6 servers can both be bound.
+type ResolveSource struct {
+	name string
+	ipv4 net.IP
+	ipv6 net.IP
+}
+
+func parseResolveSource(interfaceName, address string) (*ResolveSource, error) {
+	if address != "" {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address '%s'", address)
+		}
+		if !isLocalAddress(ip) {
+			return nil, fmt.Errorf("source address %s is not assigned to any interface", address)
+		}
+		source := &ResolveSource{name: address}
+		if ip.To4() != nil {
+			source.ipv4 = ip.To4()
+		} else {
+			source.ipv6 = ip
+		}
+		return source, nil
+	}
+
+	iface, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source interface '%s': %w", interfaceName, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of interface %s: %w", interfaceName, err)
+	}
+
+	source := &ResolveSource{name: interfaceName}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			if source.ipv4 == nil {
+				source.ipv4 = ip4
 			}
+		} else if source.ipv6 == nil {
+			source.ipv6 = ipNet.IP
 		}
 	}
+	if source.ipv4 == nil && source.ipv6 == nil {
+		return nil, fmt.Errorf("interface %s has no usable addresses", interfaceName)
+	}
+	return source, nil
+}
 
-	return nil
+func isLocalAddress(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
 }
 
-func parseWireGuardConfigForCheck(interfaceName, configPath string, configs *[]Config) error {
-	file, err := os.Open(configPath)
+// dialer returns a dialer bound to the source address matching the family of
+// server.
+func (s *ResolveSource) dialer(network, server string) (*net.Dialer, error) {
+	if s == nil {
+		return &net.Dialer{}, nil
+	}
+
+	host, _, err := net.SplitHostPort(server)
 	if err != nil {
-		return fmt.Errorf("failed to open config file %s: %w", configPath, err)
+		return nil, err
+	}
+	ip := s.ipv4
+	if serverIP := net.ParseIP(host); serverIP != nil && serverIP.To4() == nil {
+		ip = s.ipv6
+	}
+	if ip == nil {
+		return nil, fmt.Errorf("no source address on %s matches the family of DNS server %s", s.name, server)
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	endpointRegex := regexp.MustCompile(`^\s*Endpoint\s*=\s*(.+)$`)
-	ipRegex := regexp.MustCompile(`^\d+\.\d+\.\d+\.\d+`)
+	if strings.HasPrefix(network, "tcp") {
+		return &net.Dialer{LocalAddr: &net.TCPAddr{IP: ip}}, nil
+	}
+	return &net.Dialer{LocalAddr: &net.UDPAddr{IP: ip}}, nil
+}
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		matches := endpointRegex.FindStringSubmatch(line)
-		if len(matches) == 2 {
-			endpoint := strings.TrimSpace(matches[1])
+func newResolver(servers []string, proxyDialer proxy.ContextDialer, source *ResolveSource) *net.Resolver {
+	if len(servers) == 0 && proxyDialer == nil && source == nil {
+		return &net.Resolver{}
+	}
 
-			host, _, err := net.SplitHostPort(endpoint)
-			if err != nil {
-				continue
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			targets := servers
+			if len(targets) == 0 {
+				targets = []string{address}
 			}
 
-			if !ipRegex.MatchString(host) {
-				config := Config{
-					Interface: interfaceName,
-					Endpoint:  endpoint,
-					Hostname:  host,
+			var lastErr error
+			for _, server := range targets {
+				if proxyDialer != nil {
+					conn, err := proxyDialer.DialContext(ctx, "tcp", server)
+					if err == nil {
+						return conn, nil
+					}
+					lastErr = fmt.Errorf("DNS proxy connection to %s failed: %w", server, err)
+					continue
 				}
 
-				if ip, err := net.ResolveIPAddr("ip4", host); err == nil {
-					config.LastIP = ip.IP
+				dialer, err := source.dialer(network, server)
+				if err != nil {
+					lastErr = err
+					continue
 				}
-
-				*configs = append(*configs, config)
+				conn, err := dialer.DialContext(ctx, network, server)
+				if err == nil {
+					return conn, nil
+				}
+				lastErr = err
 			}
-		}
+			return nil, lastErr
+		},
 	}
-
-	return scanner.Err()
+}
+
+const (
+	familyIPv4 = "ip4"
+	familyIPv6 = "ip6"
+	familyDual = "dual"
+	familyAny  = "any"
+)
+
+// networkOf returns the network passed to the resolver for a family. familyAny
+// queries both record types and leaves the choice to the system's address
+// selection, like wg-quick's own getaddrinfo lookup.
+func networkOf(family string) string {
+	switch family {
+	case familyIPv6:
+		return "ip6"
+	case familyAny:
+		return "ip"
+	default:
+		return "ip4"
+	}
+}
+
+// matchesNetwork reports whether ip belongs to the family queried through
+// network. IPv4-mapped AAAA records are not accepted as IPv6 addresses so that
+// the tracked address always has the family that was asked for.
+func matchesNetwork(ip net.IP, network string) bool {
+	switch network {
+	case "ip4":
+		return ip.To4() != nil
+	case "ip6":
+		return ip.To4() == nil && ip.To16() != nil
+	default:
+		return ip.To16() != nil
+	}
+}
+
+type Resolution struct {
+	IPv4      net.IP
+	IPv6      net.IP
+	Chosen    net.IP
+	Addresses []net.IP
+	// Reason tells why the family of Chosen was picked in dual mode.
+	Reason string
+}
+
+const (
+	familyReasonPreferCIDR   = "prefer_cidr"
+	familyReasonPreference   = "preference"
+	familyReasonAvailability = "availability"
+)
+
+type HostResolver struct {
+	resolver   *net.Resolver
+	family     string
+	prefer     string
+	preferNets []*net.IPNet
+	dnssec     bool
+	ecs        []byte
+	cache      *DNSCache
+}
+
+// ednsClientSubnet is the EDNS option code of Client Subnet (RFC 7871).
+const ednsClientSubnet = 8
+
+// parseECS encodes the EDNS Client Subnet option data for --ecs. "disable"
+// sends a source prefix length of 0, which asks the resolver not to add the
+// client's subnet itself (RFC 7871, section 7.1.2).
+func parseECS(value string) ([]byte, error) {
+	if value == "disable" {
+		return []byte{0, 1, 0, 0}, nil
+	}
+
+	_, subnet, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EDNS Client Subnet '%s': expected disable or a subnet such as 203.0.113.0/24", value)
+	}
+
+	family, address := byte(1), subnet.IP.To4()
+	if address == nil {
+		family, address = 2, subnet.IP.To16()
+	}
+	prefix, _ := subnet.Mask.Size()
+	data := []byte{0, family, byte(prefix), 0}
+	return append(data, address[:(prefix+7)/8]...), nil
+}
+
+// DNSCache keeps successful lookups for at least --dns-cache-ttl. The record
+// TTL is only known for DNSSEC lookups, which are kept for it when it is
+// longer; lookups through the system resolver always use the floor.
+type DNSCache struct {
+	mu      sync.Mutex
+	minTTL  time.Duration
+	entries map[string]dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+func newDNSCache(minTTL time.Duration) *DNSCache {
+	return &DNSCache{minTTL: minTTL, entries: make(map[string]dnsCacheEntry)}
+}
+
+func (c *DNSCache) get(network, host string) ([]net.IP, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := network + " " + host
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	// Callers append to and reorder what they get, the entry must not
+	// share its array with them.
+	return slices.Clone(entry.ips), true
+}
+
+func (c *DNSCache) put(network, host string, ips []net.IP, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[network+" "+host] = dnsCacheEntry{ips: slices.Clone(ips), expires: time.Now().Add(max(ttl, c.minTTL))}
+}
+
+// Flush drops every cached lookup and returns how many there were.
+func (c *DNSCache) Flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	flushed := len(c.entries)
+	c.entries = make(map[string]dnsCacheEntry)
+	return flushed
+}
+
+var errDNSSECUnauthenticated = errors.New("answer not authenticated by the resolver (DNSSEC)")
+
+var errNoDNSServers = errors.New("no DNS servers to query directly, --dnssec and --ecs need --dns-server or --dns-servers-file")
+
+func (r *HostResolver) lookup(ctx context.Context, network, host string) (net.IP, error) {
+	ips, err := r.lookupAll(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+	return ips[0], nil
+}
+
+// lookupAll returns every address of host in network, in the order the
+// resolver preferred them. The result is never empty when err is nil.
+func (r *HostResolver) lookupAll(ctx context.Context, network, host string) ([]net.IP, error) {
+	if r.cache != nil {
+		if ips, ok := r.cache.get(network, host); ok {
+			return ips, nil
+		}
+	}
+
+	ips, ttl, err := r.lookupUncached(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+	if r.cache != nil {
+		r.cache.put(network, host, ips, ttl)
+	}
+	return ips, nil
+}
+
+// lookupUncached queries the resolver. The returned TTL is zero when the
+// resolver does not report one.
+func (r *HostResolver) lookupUncached(ctx context.Context, network, host string) ([]net.IP, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	if r.dnssec || r.ecs != nil {
+		if network == "ip" {
+			ips4, ttl4, err4 := r.lookupDirect(ctx, "ip4", host)
+			ips6, ttl6, err6 := r.lookupDirect(ctx, "ip6", host)
+			if err4 != nil && err6 != nil {
+				return nil, 0, err4
+			}
+			if err4 != nil {
+				return ips6, ttl6, nil
+			}
+			if err6 != nil {
+				return ips4, ttl4, nil
+			}
+			return append(ips4, ips6...), min(ttl4, ttl6), nil
+		}
+		return r.lookupDirect(ctx, network, host)
+	}
+
+	ips, err := r.resolver.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	var matching []net.IP
+	for _, ip := range ips {
+		if matchesNetwork(ip, network) {
+			matching = append(matching, normalizeIP(ip))
+		}
+	}
+	if len(matching) == 0 {
+		return nil, 0, fmt.Errorf("no %s addresses found for %s", network, host)
+	}
+	return matching, 0, nil
+}
+
+// isTransientLookupError reports whether a failed lookup is worth retrying as
+// is: timeouts and SERVFAIL say nothing about the record, while NXDOMAIN and
+// an answer without usable addresses do.
+func isTransientLookupError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsNotFound {
+			return false
+		}
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// lookupDirect queries the configured DNS servers directly, which lets the
+// query carry the EDNS Client Subnet option of --ecs. With --dnssec the DNSSEC
+// OK bit is set and only answers the validating resolver marked as
+// authenticated (AD bit) are accepted. The TTL returned is the lowest of the
+// answers.
+func (r *HostResolver) lookupDirect(ctx context.Context, network, host string) ([]net.IP, time.Duration, error) {
+	// The system resolver has no dialer of its own to send the query
+	// through.
+	if r.resolver.Dial == nil {
+		return nil, 0, errNoDNSServers
+	}
+
+	qtype := dnsmessage.TypeA
+	if network == "ip6" {
+		qtype = dnsmessage.TypeAAAA
+	}
+
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid hostname %s: %w", host, err)
+	}
+
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, r.dnssec); err != nil {
+		return nil, 0, err
+	}
+	var options []dnsmessage.Option
+	if r.ecs != nil {
+		options = append(options, dnsmessage.Option{Code: ednsClientSubnet, Data: r.ecs})
+	}
+
+	id := uint16(rand.Uint32())
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true, AuthenticData: r.dnssec},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: qtype, Class: dnsmessage.ClassINET},
+		},
+		Additionals: []dnsmessage.Resource{
+			{Header: opt, Body: &dnsmessage.OPTResource{Options: options}},
+		},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	response, err := r.exchange(ctx, "udp", packed)
+	if err == nil && response.Truncated {
+		response, err = r.exchange(ctx, "tcp", packed)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("lookup %s: %w", host, err)
+	}
+
+	if response.ID != id {
+		return nil, 0, fmt.Errorf("lookup %s: mismatched response ID", host)
+	}
+	switch response.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	case dnsmessage.RCodeServerFailure:
+		return nil, 0, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+	default:
+		return nil, 0, fmt.Errorf("lookup %s: %s", host, response.RCode)
+	}
+	if r.dnssec && !response.AuthenticData {
+		return nil, 0, fmt.Errorf("lookup %s: %w", host, errDNSSECUnauthenticated)
+	}
+
+	var ips []net.IP
+	var ttl uint32
+	for _, answer := range response.Answers {
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, normalizeIP(net.IP(body.A[:])))
+		case *dnsmessage.AAAAResource:
+			ip := net.IP(body.AAAA[:])
+			if !matchesNetwork(ip, network) {
+				continue
+			}
+			ips = append(ips, ip)
+		default:
+			continue
+		}
+		if len(ips) == 1 || answer.Header.TTL < ttl {
+			ttl = answer.Header.TTL
+		}
+	}
+	if len(ips) == 0 {
+		return nil, 0, fmt.Errorf("no addresses found for %s", host)
+	}
+	return ips, time.Duration(ttl) * time.Second, nil
+}
+
+// exchange sends a packed query through the resolver's dialer, which applies
+// --dns-server, --dns-proxy and the source binding. Stream connections, as
+// returned for TCP and through the proxy, use DNS-over-TCP framing.
+func (r *HostResolver) exchange(ctx context.Context, network string, query []byte) (*dnsmessage.Message, error) {
+	conn, err := r.resolver.Dial(ctx, network, "")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var buf []byte
+	if _, ok := conn.(net.PacketConn); ok {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[:n]
+	} else {
+		framed := append([]byte{byte(len(query) >> 8), byte(len(query))}, query...)
+		if _, err := conn.Write(framed); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, int(length[0])<<8|int(length[1]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+	}
+
+	var response dnsmessage.Message
+	if err := response.Unpack(buf); err != nil {
+		return nil, fmt.Errorf("malformed DNS response: %w", err)
+	}
+	return &response, nil
+}
+
+func (r *HostResolver) Resolve(ctx context.Context, host string) (*Resolution, error) {
+	return r.ResolveFamily(ctx, host, "")
+}
+
+// ResolveFamily resolves host for the given family, falling back to the
+// resolver's own family when family is empty.
+func (r *HostResolver) ResolveFamily(ctx context.Context, host, family string) (*Resolution, error) {
+	result := &Resolution{}
+
+	if family == "" {
+		family = r.family
+	}
+
+	switch family {
+	case familyIPv6:
+		ips, err := r.lookupAll(ctx, networkOf(family), host)
+		if err != nil {
+			return nil, err
+		}
+		chosen := r.pick(ips)
+		result.IPv6, result.Chosen, result.Addresses = chosen, chosen, ips
+	case familyAny:
+		ips, err := r.lookupAll(ctx, networkOf(family), host)
+		if err != nil {
+			return nil, err
+		}
+		chosen := r.pick(ips)
+		if chosen.To4() != nil {
+			result.IPv4 = chosen
+		} else {
+			result.IPv6 = chosen
+		}
+		result.Chosen, result.Addresses = chosen, ips
+	case familyDual:
+		ips4, err4 := r.lookupAll(ctx, "ip4", host)
+		ips6, err6 := r.lookupAll(ctx, "ip6", host)
+		if err4 != nil && err6 != nil {
+			if r.prefer == familyIPv6 {
+				return nil, err6
+			}
+			return nil, err4
+		}
+		if len(ips4) > 0 {
+			result.IPv4 = r.pick(ips4)
+		}
+		if len(ips6) > 0 {
+			result.IPv6 = r.pick(ips6)
+		}
+		result.Addresses = append(ips4, ips6...)
+
+		if preferred := r.preferredAddress(result.Addresses); preferred != nil {
+			result.Chosen = preferred
+			result.Reason = familyReasonPreferCIDR
+		} else {
+			if r.prefer == familyIPv6 && result.IPv6 != nil || result.IPv4 == nil {
+				result.Chosen = result.IPv6
+			} else {
+				result.Chosen = result.IPv4
+			}
+			result.Reason = familyReasonPreference
+			if result.IPv4 == nil || result.IPv6 == nil {
+				result.Reason = familyReasonAvailability
+			}
+		}
+	default:
+		ips, err := r.lookupAll(ctx, networkOf(family), host)
+		if err != nil {
+			// No AAAA lookup is made for the hint, ip4 mode must not query
+			// IPv6 records at all.
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+				return nil, fmt.Errorf("%w (if %s only has IPv6 addresses, use --family ip6 or dual, or a family=ipv6 annotation)", err, host)
+			}
+			return nil, err
+		}
+		chosen := r.pick(ips)
+		result.IPv4, result.Chosen, result.Addresses = chosen, chosen, ips
+	}
+
+	return result, nil
+}
+
+// parsePreferCIDRs parses the comma-separated networks of --prefer-cidr.
+func parsePreferCIDRs(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	if len(networks) == 0 {
+		return nil, fmt.Errorf("no networks given")
+	}
+	return networks, nil
+}
+
+// preferredNetwork returns the earliest --prefer-cidr network containing ip.
+func (r *HostResolver) preferredNetwork(ip net.IP) *net.IPNet {
+	if ip == nil {
+		return nil
+	}
+	for _, network := range r.preferNets {
+		if network.Contains(ip) {
+			return network
+		}
+	}
+	return nil
+}
+
+// preferredAddress returns the lowest of ips within the earliest
+// --prefer-cidr network that contains any of them, or nil when none does.
+// Taking the lowest address keeps the choice stable when the resolver
+// rotates the order of its answers.
+func (r *HostResolver) preferredAddress(ips []net.IP) net.IP {
+	for _, network := range r.preferNets {
+		var best net.IP
+		for _, ip := range ips {
+			if network.Contains(ip) && (best == nil || bytes.Compare(ip.To16(), best.To16()) < 0) {
+				best = ip
+			}
+		}
+		if best != nil {
+			return best
+		}
+	}
+	return nil
+}
+
+// pick chooses the address to use among the non-empty ips: the preferred
+// one if any, otherwise the first answer.
+func (r *HostResolver) pick(ips []net.IP) net.IP {
+	if preferred := r.preferredAddress(ips); preferred != nil {
+		return preferred
+	}
+	return ips[0]
+}
+
+// Fingerprint identifies the set of addresses a hostname resolved to,
+// independently of the order in which the resolver returned them.
+func (r *Resolution) Fingerprint() string {
+	addresses := make([]string, 0, len(r.Addresses))
+	for _, ip := range r.Addresses {
+		addresses = append(addresses, ipString(ip))
+	}
+	slices.Sort(addresses)
+	return strings.Join(slices.Compact(addresses), ",")
+}
+
+type EndpointKey struct {
+	Interface string `json:"interface"`
+	Hostname  string `json:"hostname"`
+}
+
+// State is the runtime state persisted to --state-file so that it survives
+// restarts of the daemon.
+type State struct {
+	Disabled      []EndpointKey  `json:"disabled,omitempty"`
+	PortOverrides []PortOverride `json:"port_overrides,omitempty"`
+	Maintenance   bool           `json:"maintenance,omitempty"`
+}
+
+type PortOverride struct {
+	Interface string `json:"interface"`
+	Hostname  string `json:"hostname"`
+	Port      string `json:"port"`
+}
+
+func loadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file %s: %w", path, err)
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+func saveState(path string, state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write state file %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace state file %s: %w", path, err)
+	}
+	return nil
+}
+
+type DDNSMonitor struct {
+	configs                []Config
+	published              atomic.Pointer[[]Config]
+	work                   []Config
+	workBase               []Config
+	conn                   *dbus.Conn
+	singleInterface        string
+	interfaceFilter        *InterfaceFilter
+	namePattern            *regexp.Regexp
+	apiEnabled             bool
+	listenAddress          string
+	listenPort             string
+	apiKey                 string
+	apiKeyScopes           map[string]*InterfaceFilter
+	httpServer             *http.Server
+	checkInterval          time.Duration
+	minInterval            time.Duration
+	maxInterval            time.Duration
+	failoverAfter          int
+	failAction             string
+	failThreshold          int
+	transientFailThreshold int
+	maxBodySize            int64
+	endpointSource         string
+	endpointSelection      string
+	monitorHostnames       *MonitorHostnames
+	compareMode            string
+	disableSwagger         bool
+	disableGzip            bool
+	dnsCache               *DNSCache
+	dashboard              bool
+	dnsServers             []string
+	dnsServersFile         string
+	dnsProxy               proxy.ContextDialer
+	dnssec                 bool
+	ecs                    []byte
+	resolveSource          *ResolveSource
+	family                 string
+	preferFamily           string
+	preferNets             []*net.IPNet
+	resolver               atomic.Pointer[HostResolver]
+	manualCooldown         time.Duration
+	startupGrace           time.Duration
+	waitForConfig          time.Duration
+	checkOffset            time.Duration
+	coalesceWindow         time.Duration
+	startedAt              time.Time
+	ready                  atomic.Bool
+	maintenance            atomic.Bool
+	loopLag                atomic.Int64
+	checkDuration          atomic.Int64
+	checkOverruns          atomic.Uint64
+	restartMode            string
+	systemdBus             string
+	unitPrefix             string
+	changeWindowHits       int
+	changeWindowSize       int
+	stateFile              string
+	stateMu                sync.Mutex
+	notifyCommand          []*template.Template
+	notifyBatch            bool
+	pendingChanges         []ChangeEvent
+	auditOnly              bool
+	audit                  *AuditLog
+	notifyWG               sync.WaitGroup
+	restartMu              sync.Mutex
+	restarting             map[string]bool
+	lastRestart            map[string]time.Time
+	deferredRestarts       map[string]bool
+	restartTimes           []time.Time
+	maxRestartsHour        int
+	checkMu                sync.Mutex
+	cycleMu                sync.Mutex
+	connMu                 sync.Mutex
+}
+
+const restartTimeout = 60 * time.Second
+
+const (
+	reconnectAttempts = 3
+	reconnectBackoff  = time.Second
+)
+
+const (
+	restartModeRestart         = "restart"
+	restartModeReload          = "reload"
+	restartModeReloadOrRestart = "reload-or-restart"
+)
+
+const (
+	failActionIgnore  = "ignore"
+	failActionNotify  = "notify"
+	failActionRestart = "restart"
+)
+
+var errRestartInProgress = errors.New("restart already in progress")
+
+var errRestartLimitReached = errors.New("restart limit reached")
+
+const restartLimitWindow = time.Hour
+
+type RestartRequest struct {
+	Interface string `json:"interface" binding:"required"`
+}
+
+type RestartResponse struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+}
+
+// ErrorResponse is the body of every API error, whether the request was
+// rejected by authentication, failed validation or the operation itself failed.
+type ErrorResponse struct {
+	Code    string      `json:"code" example:"missing_field"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty" swaggertype:"object"`
+}
+
+const (
+	errorCodeEmptyBody          = "empty_body"
+	errorCodeMalformedJSON      = "malformed_json"
+	errorCodeInvalidField       = "invalid_field"
+	errorCodeMissingField       = "missing_field"
+	errorCodeBodyTooLarge       = "body_too_large"
+	errorCodeAuditOnly          = "audit_only"
+	errorCodeRestartLimit       = "restart_limit"
+	errorCodeInterfaceForbidden = "interface_forbidden"
+	errorCodeInvalidInterface   = "invalid_interface"
+	errorCodeInvalidParameter   = "invalid_parameter"
+	errorCodeUnauthorized       = "unauthorized"
+	errorCodeNotFound           = "not_found"
+	errorCodeMethodNotAllowed   = "method_not_allowed"
+	errorCodeInterfaceNotFound  = "interface_not_found"
+	errorCodeSingleInterface    = "single_interface_mode"
+	errorCodeFeatureDisabled    = "feature_disabled"
+	errorCodeRestartInProgress  = "restart_in_progress"
+	errorCodeRestartFailed      = "restart_failed"
+	errorCodeDiscoveryFailed    = "discovery_failed"
+	errorCodePersistFailed      = "persist_failed"
+	errorCodeMaintenance        = "maintenance"
+	errorCodeScopeForbidden     = "scope_forbidden"
+)
+
+func respondError(c *gin.Context, status int, code, message string) {
+	c.JSON(status, ErrorResponse{Code: code, Message: message})
+}
+
+// describeBindingError classifies an error from binding a JSON body into
+// request, returning a machine-readable code and a message naming the problem.
+func describeBindingError(err error, request interface{}) (string, string) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var validationErrs validator.ValidationErrors
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return errorCodeEmptyBody, "Request body is empty, expected a JSON object"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errorCodeMalformedJSON, "Malformed JSON: unexpected end of input"
+	case errors.As(err, &syntaxErr):
+		return errorCodeMalformedJSON, fmt.Sprintf("Malformed JSON at offset %d: %v", syntaxErr.Offset, syntaxErr)
+	case errors.As(err, &typeErr):
+		return errorCodeInvalidField, fmt.Sprintf("Field '%s' must be of type %s", typeErr.Field, typeErr.Type)
+	case errors.As(err, &validationErrs) && len(validationErrs) > 0:
+		field := jsonFieldName(request, validationErrs[0].StructField())
+		if validationErrs[0].Tag() == "required" {
+			return errorCodeMissingField, fmt.Sprintf("Missing required field '%s'", field)
+		}
+		return errorCodeInvalidField, fmt.Sprintf("Field '%s' failed validation '%s'", field, validationErrs[0].Tag())
+	default:
+		return errorCodeMalformedJSON, "Invalid request format"
+	}
+}
+
+func jsonFieldName(request interface{}, structField string) string {
+	t := reflect.TypeOf(request)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	field, ok := t.FieldByName(structField)
+	if !ok {
+		return structField
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return structField
+	}
+	return name
+}
+
+type LogsResponse struct {
+	Entries []LogEntry `json:"entries"`
+}
+
+type InterfaceRestartResult struct {
+	Interface  string `json:"interface"`
+	Success    bool   `json:"success"`
+	Message    string `json:"message"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type RestartAllResponse struct {
+	Success bool                     `json:"success"`
+	Message string                   `json:"message"`
+	Results []InterfaceRestartResult `json:"results"`
+}
+
+type DiscoverResponse struct {
+	Success bool     `json:"success"`
+	Message string   `json:"message"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+type PreviewEndpoint struct {
+	Interface string `json:"interface"`
+	Hostname  string `json:"hostname"`
+	OldIP     string `json:"old_ip,omitempty"`
+	NewIP     string `json:"new_ip,omitempty"`
+	Action    string `json:"action"`
+	Reason    string `json:"reason,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type PreviewResponse struct {
+	Restarts  []string          `json:"restarts"`
+	Endpoints []PreviewEndpoint `json:"endpoints"`
+}
+
+const (
+	previewActionNone       = "none"
+	previewActionRestart    = "restart"
+	previewActionDeferred   = "deferred"
+	previewActionUpdate     = "update"
+	previewActionDisabled   = "disabled"
+	previewActionUnresolved = "unresolved"
+)
+
+type PortOverrideRequest struct {
+	Port *int `json:"port" binding:"required,min=0,max=65535"`
+}
+
+type RestartBreakerStatus struct {
+	MaxPerHour       int    `json:"max_per_hour"`
+	RestartsLastHour int    `json:"restarts_last_hour"`
+	Open             bool   `json:"open"`
+	ResetsAt         string `json:"resets_at,omitempty"`
+}
+
+type StatusResponse struct {
+	SingleInterfaceMode bool                 `json:"single_interface_mode"`
+	MonitoredCount      int                  `json:"monitored_count"`
+	DisabledCount       int                  `json:"disabled_count"`
+	Ready               bool                 `json:"ready"`
+	Maintenance         bool                 `json:"maintenance"`
+	RestartBreaker      RestartBreakerStatus `json:"restart_breaker"`
+}
+
+type MaintenanceRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+type Args struct {
+	singleInterface        string
+	interfaces             string
+	interfaceNamePattern   string
+	listenAddress          string
+	listenPort             string
+	apiKey                 string
+	apiKeysFile            string
+	logLevel               string
+	logTimestampFormat     string
+	logBufferSize          string
+	checkInterval          string
+	minCheckInterval       string
+	maxCheckInterval       string
+	failoverAfter          string
+	failAction             string
+	failThreshold          string
+	transientFailThreshold string
+	maxRestartsPerHour     string
+	manualCooldown         string
+	startupGrace           string
+	waitForConfig          string
+	checkOffset            string
+	coalesceWindow         string
+	dnsCacheTTL            string
+	restartMode            string
+	systemdBus             string
+	unitPrefix             string
+	changeWindow           string
+	stateFile              string
+	notifyExec             string
+	auditFile              string
+	maxBodySize            string
+	endpointSource         string
+	endpointSelection      string
+	monitorHostname        string
+	compareMode            string
+	dnsServer              string
+	dnsServersFile         string
+	dnsProxy               string
+	ecs                    string
+	resolveSourceInterface string
+	resolveSourceIP        string
+	family                 string
+	preferFamily           string
+	preferCIDR             string
+	requireStrong          bool
+	noColor                bool
+	dnssec                 bool
+	logSyslog              bool
+	auditOnly              bool
+	notifyBatch            bool
+	startInMaintenance     bool
+	disableSwagger         bool
+	disableGzip            bool
+	dashboard              bool
+	help                   bool
+	version                bool
+	checkOnly              bool
+	configStdin            bool
+	selftest               bool
+	once                   bool
+	command                string
+	output                 string
+}
+
+func parseChangeWindow(value string) (int, int, error) {
+	hitsValue, sizeValue, found := strings.Cut(value, "/")
+	if !found {
+		return 0, 0, fmt.Errorf("'%s' must be in K/M form, e.g. 3/5", value)
+	}
+
+	hits, err := strconv.Atoi(strings.TrimSpace(hitsValue))
+	if err != nil {
+		return 0, 0, fmt.Errorf("'%s' must be in K/M form, e.g. 3/5", value)
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(sizeValue))
+	if err != nil {
+		return 0, 0, fmt.Errorf("'%s' must be in K/M form, e.g. 3/5", value)
+	}
+	if hits < 1 || size < hits {
+		return 0, 0, fmt.Errorf("'%s' must satisfy 1 <= K <= M", value)
+	}
+
+	return hits, size, nil
+}
+
+func parseBoolEnv(name string) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	return err == nil && value
+}
+
+func parseArgs() *Args {
+	args := &Args{}
+
+	args.singleInterface = os.Getenv("WG_DDNS_SINGLE_INTERFACE")
+	args.interfaces = os.Getenv("WG_DDNS_INTERFACES")
+	args.interfaceNamePattern = os.Getenv("WG_DDNS_INTERFACE_NAME_PATTERN")
+	args.listenAddress = os.Getenv("WG_DDNS_LISTEN_ADDRESS")
+	args.listenPort = os.Getenv("WG_DDNS_LISTEN_PORT")
+	args.apiKey = os.Getenv("WG_DDNS_API_KEY")
+	args.apiKeysFile = os.Getenv("WG_DDNS_API_KEYS_FILE")
+	args.logLevel = os.Getenv("WG_DDNS_LOG_LEVEL")
+	args.logTimestampFormat = os.Getenv("WG_DDNS_LOG_TIMESTAMP_FORMAT")
+	args.logBufferSize = os.Getenv("WG_DDNS_LOG_BUFFER_SIZE")
+	args.checkInterval = os.Getenv("WG_DDNS_CHECK_INTERVAL")
+	args.minCheckInterval = os.Getenv("WG_DDNS_MIN_CHECK_INTERVAL")
+	args.maxCheckInterval = os.Getenv("WG_DDNS_MAX_CHECK_INTERVAL")
+	args.failoverAfter = os.Getenv("WG_DDNS_FAILOVER_AFTER")
+	args.failAction = os.Getenv("WG_DDNS_FAIL_ACTION")
+	args.failThreshold = os.Getenv("WG_DDNS_FAIL_THRESHOLD")
+	args.transientFailThreshold = os.Getenv("WG_DDNS_TRANSIENT_FAIL_THRESHOLD")
+	args.maxRestartsPerHour = os.Getenv("WG_DDNS_MAX_RESTARTS_PER_HOUR")
+	args.manualCooldown = os.Getenv("WG_DDNS_MANUAL_RESTART_COOLDOWN")
+	args.startupGrace = os.Getenv("WG_DDNS_STARTUP_GRACE")
+	args.waitForConfig = os.Getenv("WG_DDNS_WAIT_FOR_CONFIG")
+	args.checkOffset = os.Getenv("WG_DDNS_CHECK_OFFSET")
+	args.coalesceWindow = os.Getenv("WG_DDNS_COALESCE_WINDOW")
+	args.dnsCacheTTL = os.Getenv("WG_DDNS_DNS_CACHE_TTL")
+	args.restartMode = os.Getenv("WG_DDNS_RESTART_MODE")
+	args.systemdBus = os.Getenv("WG_DDNS_SYSTEMD_BUS")
+	args.unitPrefix = os.Getenv("WG_DDNS_UNIT_PREFIX")
+	args.changeWindow = os.Getenv("WG_DDNS_CHANGE_WINDOW")
+	args.stateFile = os.Getenv("WG_DDNS_STATE_FILE")
+	args.notifyExec = os.Getenv("WG_DDNS_NOTIFY_EXEC")
+	args.auditFile = os.Getenv("WG_DDNS_AUDIT_FILE")
+	args.maxBodySize = os.Getenv("WG_DDNS_MAX_BODY_SIZE")
+	args.endpointSource = os.Getenv("WG_DDNS_ENDPOINT_SOURCE")
+	args.endpointSelection = os.Getenv("WG_DDNS_ENDPOINT_SELECTION")
+	args.monitorHostname = os.Getenv("WG_DDNS_MONITOR_HOSTNAME")
+	args.compareMode = os.Getenv("WG_DDNS_COMPARE_MODE")
+	args.dnsServer = os.Getenv("WG_DDNS_DNS_SERVER")
+	args.dnsServersFile = os.Getenv("WG_DDNS_DNS_SERVERS_FILE")
+	args.dnsProxy = os.Getenv("WG_DDNS_DNS_PROXY")
+	args.ecs = os.Getenv("WG_DDNS_ECS")
+	args.resolveSourceInterface = os.Getenv("WG_DDNS_RESOLVE_SOURCE_INTERFACE")
+	args.resolveSourceIP = os.Getenv("WG_DDNS_RESOLVE_SOURCE_IP")
+	args.family = os.Getenv("WG_DDNS_FAMILY")
+	args.preferFamily = os.Getenv("WG_DDNS_PREFER_FAMILY")
+	args.preferCIDR = os.Getenv("WG_DDNS_PREFER_CIDR")
+	args.disableSwagger = parseBoolEnv("WG_DDNS_DISABLE_SWAGGER")
+	args.disableGzip = parseBoolEnv("WG_DDNS_DISABLE_GZIP")
+	args.requireStrong = parseBoolEnv("WG_DDNS_REQUIRE_STRONG_KEY")
+	args.dashboard = parseBoolEnv("WG_DDNS_DASHBOARD")
+	args.noColor = parseBoolEnv("WG_DDNS_NO_COLOR")
+	args.dnssec = parseBoolEnv("WG_DDNS_DNSSEC")
+	args.logSyslog = parseBoolEnv("WG_DDNS_LOG_SYSLOG")
+	args.auditOnly = parseBoolEnv("WG_DDNS_AUDIT_ONLY")
+	args.notifyBatch = parseBoolEnv("WG_DDNS_NOTIFY_BATCH")
+	args.startInMaintenance = parseBoolEnv("WG_DDNS_START_IN_MAINTENANCE")
+
+	seen := make(map[string]bool)
+	listOptions := map[string]bool{
+		"--interfaces":       true,
+		"--dns-server":       true,
+		"--monitor-hostname": true,
+	}
+
+	for i := 1; i < len(os.Args); i++ {
+		arg := os.Args[i]
+
+		if !strings.HasPrefix(arg, "--") {
+			if arg == "-h" || arg == "-help" {
+				args.help = true
+				continue
+			}
+			if i == 1 && (arg == commandList || arg == commandCheck) {
+				args.command = arg
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "Error: Invalid argument format '%s'. Only double-dash (--) options are supported.\n", arg)
+			os.Exit(1)
+		}
+
+		if arg == "--help" {
+			args.help = true
+			continue
+		}
+
+		if arg == "--version" {
+			args.version = true
+			continue
+		}
+
+		if arg == "--once" {
+			args.once = true
+			continue
+		}
+
+		if arg == "--selftest" {
+			args.selftest = true
+			continue
+		}
+
+		if arg == "--check-only" {
+			args.checkOnly = true
+			continue
+		}
+
+		if arg == "--config-stdin" {
+			args.configStdin = true
+			continue
+		}
+
+		if arg == "--disable-swagger" {
+			args.disableSwagger = true
+			continue
+		}
+
+		if arg == "--disable-gzip" {
+			args.disableGzip = true
+			continue
+		}
+
+		if arg == "--dashboard" {
+			args.dashboard = true
+			continue
+		}
+
+		if arg == "--require-strong-key" {
+			args.requireStrong = true
+			continue
+		}
+
+		if arg == "--dnssec" {
+			args.dnssec = true
+			continue
+		}
+
+		if arg == "--no-color" {
+			args.noColor = true
+			continue
+		}
+
+		if arg == "--log-syslog" {
+			args.logSyslog = true
+			continue
+		}
+
+		if arg == "--audit-only" {
+			args.auditOnly = true
+			continue
+		}
+
+		if arg == "--notify-batch" {
+			args.notifyBatch = true
+			continue
+		}
+
+		if arg == "--start-in-maintenance" {
+			args.startInMaintenance = true
+			continue
+		}
+
+		parts := strings.SplitN(arg, "=", 2)
+		var key, value string
+
+		if len(parts) == 2 {
+			key = parts[0]
+			value = parts[1]
+		} else {
+			key = arg
+			if i+1 < len(os.Args) && !strings.HasPrefix(os.Args[i+1], "--") {
+				i++
+				value = os.Args[i]
+			}
+		}
+
+		repeated := seen[key]
+		seen[key] = true
+
+		switch key {
+		case "--single-interface":
+			args.singleInterface = value
+		case "--interfaces":
+			args.interfaces = appendListValue(args.interfaces, value, repeated)
+		case "--interface-name-pattern":
+			args.interfaceNamePattern = value
+		case "--listen-address":
+			args.listenAddress = value
+		case "--listen-port":
+			args.listenPort = value
+		case "--api-key":
+			args.apiKey = value
+		case "--api-keys-file":
+			args.apiKeysFile = value
+		case "--log-level":
+			args.logLevel = value
+		case "--log-timestamp-format":
+			args.logTimestampFormat = value
+		case "--log-buffer-size":
+			args.logBufferSize = value
+		case "--check-interval":
+			args.checkInterval = value
+		case "--min-check-interval":
+			args.minCheckInterval = value
+		case "--max-check-interval":
+			args.maxCheckInterval = value
+		case "--failover-after":
+			args.failoverAfter = value
+		case "--fail-action":
+			args.failAction = value
+		case "--fail-threshold":
+			args.failThreshold = value
+		case "--transient-fail-threshold":
+			args.transientFailThreshold = value
+		case "--max-restarts-per-hour":
+			args.maxRestartsPerHour = value
+		case "--manual-restart-cooldown":
+			args.manualCooldown = value
+		case "--startup-grace":
+			args.startupGrace = value
+		case "--wait-for-config":
+			args.waitForConfig = value
+		case "--check-offset":
+			args.checkOffset = value
+		case "--coalesce-window":
+			args.coalesceWindow = value
+		case "--dns-cache-ttl":
+			args.dnsCacheTTL = value
+		case "--systemd-bus":
+			args.systemdBus = value
+		case "--unit-prefix":
+			args.unitPrefix = value
+		case "--restart-mode":
+			args.restartMode = value
+		case "--change-window":
+			args.changeWindow = value
+		case "--state-file":
+			args.stateFile = value
+		case "--notify-exec":
+			args.notifyExec = value
+		case "--audit-file":
+			args.auditFile = value
+		case "--max-body-size":
+			args.maxBodySize = value
+		case "--endpoint-source":
+			args.endpointSource = value
+		case "--endpoint-selection":
+			args.endpointSelection = value
+		case "--monitor-hostname":
+			args.monitorHostname = appendListValue(args.monitorHostname, value, repeated)
+		case "--compare-mode":
+			args.compareMode = value
+		case "--dns-server":
+			args.dnsServer = appendListValue(args.dnsServer, value, repeated)
+		case "--dns-servers-file":
+			args.dnsServersFile = value
+		case "--dns-proxy":
+			args.dnsProxy = value
+		case "--ecs":
+			args.ecs = value
+		case "--resolve-source-interface":
+			args.resolveSourceInterface = value
+		case "--resolve-source-ip":
+			args.resolveSourceIP = value
+		case "--family":
+			args.family = value
+		case "--prefer-family":
+			args.preferFamily = value
+		case "--prefer-cidr":
+			args.preferCIDR = value
+		case "--output":
+			args.output = value
+		default:
+			fmt.Fprintf(os.Stderr, "Error: Unknown option '%s'\n", key)
+			os.Exit(1)
+		}
+
+		if repeated && !listOptions[key] {
+			fmt.Fprintf(os.Stderr, "Warning: Option '%s' specified more than once, using the last value\n", key)
+		}
+	}
+
+	return args
+}
+
+func appendListValue(current, value string, repeated bool) string {
+	if !repeated || current == "" {
+		return value
+	}
+	return current + "," + value
+}
+
+func printUsage() {
+	fmt.Printf("Usage: %s [COMMAND] [OPTIONS]\n\n", os.Args[0])
+	fmt.Println("COMMANDS:")
+	fmt.Println("  list                         List active WireGuard interfaces with domain endpoints and exit (same as --check-only)")
+	fmt.Println("  check                        Compare resolved addresses with the endpoints in use by the kernel and exit")
+	fmt.Println("  (none)                       Run the monitor daemon")
+	fmt.Println("")
+	fmt.Println("OPTIONS:")
+	fmt.Println("  --single-interface string    Monitor only the specified WireGuard interface")
+	fmt.Println("  --interfaces string          Comma-separated glob patterns of interfaces to monitor, prefix with ! to exclude")
+	fmt.Println("  --interface-name-pattern string")
+	fmt.Println("                               Regular expression interface names must match to be monitored or acted on over the API (default: ^[A-Za-z0-9_=+.-]{1,15}$)")
+	fmt.Println("  --listen-address string      HTTP API listen address")
+	fmt.Println("  --listen-port string         HTTP API listen port")
+	fmt.Println("  --api-key string             API key for authentication")
+	fmt.Println("  --api-keys-file string       File of additional API keys, each limited to a list of interfaces")
+	fmt.Println("  --log-level string           Log level: debug, info, warn, error (default: info)")
+	fmt.Println("  --log-timestamp-format string")
+	fmt.Println("                               Log timestamp Go layout or rfc3339, rfc3339nano, unix (default: 2006/01/02 15:04:05)")
+	fmt.Println("  --log-buffer-size int        Number of recent log lines kept for the logs API, 0 disables it (default: 500)")
+	fmt.Println("  --no-color                   Disable colored log levels (colors are only used when stdout is a terminal)")
+	fmt.Println("  --log-syslog                 Send logs to the local syslog daemon (facility daemon) instead of stdout")
+	fmt.Println("  --check-interval string      DNS check interval (e.g., 10s, 1m, 5m) (default: 10s)")
+	fmt.Println("  --min-check-interval string  Lower bound of adaptive per-endpoint intervals (default: --check-interval)")
+	fmt.Println("  --max-check-interval string  Upper bound of adaptive per-endpoint intervals (default: --check-interval)")
+	fmt.Println("  --failover-after int         Consecutive primary resolution failures before switching to a backup endpoint (default: 3)")
+	fmt.Println("  --fail-action string         Action after repeated resolution failures: ignore, notify, restart (default: ignore)")
+	fmt.Println("  --fail-threshold int         Consecutive resolution failures before --fail-action is taken (default: 3)")
+	fmt.Println("  --transient-fail-threshold int")
+	fmt.Println("                               Consecutive failures before --fail-action is taken when the latest is a timeout or SERVFAIL (default: 10)")
+	fmt.Println("  --max-restarts-per-hour int  Refuse further restarts once this many happened within an hour (default: unlimited)")
+	fmt.Println("  --manual-restart-cooldown string")
+	fmt.Println("                               Defer automatic restarts this long after an interface was restarted outside wg-ddns (default: disabled)")
+	fmt.Println("  --startup-grace string       Only log and track IP changes for this long after startup, without restarting (default: disabled)")
+	fmt.Println("  --wait-for-config string     Wait this long for the --single-interface config file to appear at startup (default: disabled)")
+	fmt.Println("  --check-offset string        Delay the schedule of checks by this much, shorter than the interval (default: 0)")
+	fmt.Println("  --coalesce-window string     Wait this long after a change to batch further changes into one restart (default: disabled)")
+	fmt.Println("  --dns-cache-ttl string       Cache successful lookups for at least this long (default: disabled)")
+	fmt.Println("  --restart-mode string        How units are cycled: restart, reload, reload-or-restart (default: restart)")
+	fmt.Println("  --systemd-bus string         systemd instance to manage units through: system, user, auto (default: system)")
+	fmt.Println("  --unit-prefix string         Template unit prefix of the interface units to discover and restart (default: wg-quick@)")
+	fmt.Println("  --change-window string       Only act on a new IP seen on K of the last M checks, as K/M (default: disabled)")
+	fmt.Println("  --state-file string          File in which runtime state such as disabled endpoints is kept across restarts")
+	fmt.Println("  --start-in-maintenance       Start with checks paused until maintenance mode is turned off through the API")
+	fmt.Println("  --notify-exec string         Command run on each IP change, a template using {{.Interface}}, {{.Hostname}}, {{.OldIP}}, {{.NewIP}}")
+	fmt.Println("  --notify-batch               Run --notify-exec once per check with all changes as JSON on stdin")
+	fmt.Println("  --audit-only                 Only record what every endpoint resolves to on each check, never restart anything")
+	fmt.Println("  --audit-file string          CSV file to which --audit-only appends one record per endpoint and check")
+	fmt.Println("  --endpoint-source string     Peer source: file (config file) or showconf (peers running per wg showconf) (default: file)")
+	fmt.Println("  --endpoint-selection string  Endpoint monitored for peers listing several: first or last, as wg uses (default: last)")
+	fmt.Println("  --monitor-hostname string    Hostname tracked for IP-literal endpoints without a monitor= annotation, as host or interface=host")
+	fmt.Println("  --compare-mode string        What counts as a change: address (the chosen address) or set (any resolved address) (default: address)")
+	fmt.Println("  --dns-server string          Comma-separated DNS servers (IP[:port]) used instead of the system resolver")
+	fmt.Println("  --dns-servers-file string    File listing DNS servers, one per line, re-read on SIGHUP")
+	fmt.Println("  --dns-proxy string           SOCKS5 proxy (socks5://host:port) used to reach DNS servers over TCP")
+	fmt.Println("  --resolve-source-interface string")
+	fmt.Println("                               Send DNS queries from the addresses of this interface")
+	fmt.Println("  --resolve-source-ip string   Send DNS queries from this local address")
+	fmt.Println("  --dnssec                     Only accept answers a validating resolver on a loopback address authenticated (AD bit)")
+	fmt.Println("  --ecs string                 EDNS Client Subnet sent to the DNS servers: disable or a subnet (default: not sent)")
+	fmt.Println("  --family string              Address family to resolve and track: ip4, ip6, dual, any (default: ip4)")
+	fmt.Println("  --prefer-family string       Family whose address is used in dual mode when both resolve: ip4, ip6 (default: ip4)")
+	fmt.Println("  --prefer-cidr string         Comma-separated networks, in order of preference, to choose the endpoint address from")
+	fmt.Println("  --max-body-size int          Maximum request body size in bytes for mutating API endpoints (default: 4096)")
+	fmt.Println("  --dashboard                  Serve a minimal web dashboard at / on the HTTP API")
+	fmt.Println("  --require-strong-key         Refuse to start with a weak API key instead of only warning")
+	fmt.Println("  --disable-swagger            Do not serve the Swagger UI on the HTTP API")
+	fmt.Println("  --disable-gzip               Do not gzip large API responses for clients that accept it")
+	fmt.Println("  --check-only                 Check active WireGuard interfaces and exit")
+	fmt.Println("  --config-stdin               Read the config of list and check from standard input instead of /etc/wireguard")
+	fmt.Println("  --once                       Run a single check cycle and exit with 0 (no change), 10 (change applied), 20 (change not applied) or 1 (error)")
+	fmt.Println("  --selftest                   Verify systemd, config, DNS and unit availability, print a report and exit")
+	fmt.Println("  --output string              Output format of list, check and --selftest: text, json (default: text)")
+	fmt.Println("  --version                    Show version information")
+	fmt.Println("  --help                       Show this help message")
+	fmt.Println("")
+	fmt.Println("ENVIRONMENT VARIABLES:")
+	fmt.Println("  WG_DDNS_SINGLE_INTERFACE     Same as --single-interface")
+	fmt.Println("  WG_DDNS_INTERFACES           Same as --interfaces")
+	fmt.Println("  WG_DDNS_INTERFACE_NAME_PATTERN")
+	fmt.Println("                               Same as --interface-name-pattern")
+	fmt.Println("  WG_DDNS_LISTEN_ADDRESS       Same as --listen-address")
+	fmt.Println("  WG_DDNS_LISTEN_PORT          Same as --listen-port")
+	fmt.Println("  WG_DDNS_API_KEY              Same as --api-key")
+	fmt.Println("  WG_DDNS_API_KEYS_FILE        Same as --api-keys-file")
+	fmt.Println("  WG_DDNS_LOG_LEVEL            Same as --log-level")
+	fmt.Println("  WG_DDNS_LOG_TIMESTAMP_FORMAT")
+	fmt.Println("                               Same as --log-timestamp-format")
+	fmt.Println("  WG_DDNS_LOG_BUFFER_SIZE      Same as --log-buffer-size")
+	fmt.Println("  WG_DDNS_CHECK_INTERVAL       Same as --check-interval")
+	fmt.Println("  WG_DDNS_MIN_CHECK_INTERVAL   Same as --min-check-interval")
+	fmt.Println("  WG_DDNS_MAX_CHECK_INTERVAL   Same as --max-check-interval")
+	fmt.Println("  WG_DDNS_FAILOVER_AFTER       Same as --failover-after")
+	fmt.Println("  WG_DDNS_FAIL_ACTION          Same as --fail-action")
+	fmt.Println("  WG_DDNS_FAIL_THRESHOLD       Same as --fail-threshold")
+	fmt.Println("  WG_DDNS_TRANSIENT_FAIL_THRESHOLD")
+	fmt.Println("                               Same as --transient-fail-threshold")
+	fmt.Println("  WG_DDNS_MAX_RESTARTS_PER_HOUR")
+	fmt.Println("                               Same as --max-restarts-per-hour")
+	fmt.Println("  WG_DDNS_MANUAL_RESTART_COOLDOWN")
+	fmt.Println("                               Same as --manual-restart-cooldown")
+	fmt.Println("  WG_DDNS_STARTUP_GRACE        Same as --startup-grace")
+	fmt.Println("  WG_DDNS_WAIT_FOR_CONFIG      Same as --wait-for-config")
+	fmt.Println("  WG_DDNS_CHECK_OFFSET         Same as --check-offset")
+	fmt.Println("  WG_DDNS_COALESCE_WINDOW      Same as --coalesce-window")
+	fmt.Println("  WG_DDNS_DNS_CACHE_TTL        Same as --dns-cache-ttl")
+	fmt.Println("  WG_DDNS_RESTART_MODE         Same as --restart-mode")
+	fmt.Println("  WG_DDNS_SYSTEMD_BUS          Same as --systemd-bus")
+	fmt.Println("  WG_DDNS_UNIT_PREFIX          Same as --unit-prefix")
+	fmt.Println("  WG_DDNS_CHANGE_WINDOW        Same as --change-window")
+	fmt.Println("  WG_DDNS_STATE_FILE           Same as --state-file")
+	fmt.Println("  WG_DDNS_START_IN_MAINTENANCE Same as --start-in-maintenance (true/false)")
+	fmt.Println("  WG_DDNS_NOTIFY_EXEC          Same as --notify-exec")
+	fmt.Println("  WG_DDNS_NOTIFY_BATCH         Same as --notify-batch (true/false)")
+	fmt.Println("  WG_DDNS_AUDIT_ONLY           Same as --audit-only (true/false)")
+	fmt.Println("  WG_DDNS_AUDIT_FILE           Same as --audit-file")
+	fmt.Println("  WG_DDNS_ENDPOINT_SOURCE      Same as --endpoint-source")
+	fmt.Println("  WG_DDNS_ENDPOINT_SELECTION   Same as --endpoint-selection")
+	fmt.Println("  WG_DDNS_MONITOR_HOSTNAME     Same as --monitor-hostname")
+	fmt.Println("  WG_DDNS_COMPARE_MODE         Same as --compare-mode")
+	fmt.Println("  WG_DDNS_DNS_SERVER           Same as --dns-server")
+	fmt.Println("  WG_DDNS_DNS_SERVERS_FILE     Same as --dns-servers-file")
+	fmt.Println("  WG_DDNS_DNS_PROXY            Same as --dns-proxy")
+	fmt.Println("  WG_DDNS_RESOLVE_SOURCE_INTERFACE")
+	fmt.Println("                               Same as --resolve-source-interface")
+	fmt.Println("  WG_DDNS_RESOLVE_SOURCE_IP    Same as --resolve-source-ip")
+	fmt.Println("  WG_DDNS_DNSSEC               Same as --dnssec (true/false)")
+	fmt.Println("  WG_DDNS_ECS                  Same as --ecs")
+	fmt.Println("  WG_DDNS_FAMILY               Same as --family")
+	fmt.Println("  WG_DDNS_PREFER_FAMILY        Same as --prefer-family")
+	fmt.Println("  WG_DDNS_PREFER_CIDR          Same as --prefer-cidr")
+	fmt.Println("  WG_DDNS_MAX_BODY_SIZE        Same as --max-body-size")
+	fmt.Println("  WG_DDNS_DISABLE_SWAGGER      Same as --disable-swagger (true/false)")
+	fmt.Println("  WG_DDNS_DISABLE_GZIP         Same as --disable-gzip (true/false)")
+	fmt.Println("  WG_DDNS_DASHBOARD            Same as --dashboard (true/false)")
+	fmt.Println("  WG_DDNS_REQUIRE_STRONG_KEY   Same as --require-strong-key (true/false)")
+	fmt.Println("  WG_DDNS_NO_COLOR             Same as --no-color (true/false)")
+	fmt.Println("  WG_DDNS_LOG_SYSLOG           Same as --log-syslog (true/false)")
+	fmt.Println("")
+	fmt.Println("NOTES:")
+	fmt.Println("  - All three API options (--listen-address, --listen-port, --api-key) must be provided together to enable API functionality")
+	fmt.Println("  - --interfaces only applies to auto-discovery and cannot be combined with --single-interface")
+	fmt.Println("  - Backup endpoints are declared inline: Endpoint = vpn.example.com:51820 # backup=vpn-backup.example.com:51820")
+	fmt.Println("  - Sending SIGHUP rebuilds the DNS resolver, re-reading --dns-servers-file")
+	fmt.Println("  - --interfaces and --dns-server may be repeated to add values, other options keep the last value given")
+	fmt.Println("  - Command line options override environment variables")
+	fmt.Println("  - Use double-dash (--) format for all options")
+}
+
+func printVersion() {
+	fmt.Printf("wg-ddns version %s\n", Version)
+}
+
+const (
+	commandList  = "list"
+	commandCheck = "check"
+
+	outputText = "text"
+	outputJSON = "json"
+)
+
+type CheckOptions struct {
+	Command          string
+	Output           string
+	SingleInterface  string
+	Filter           *InterfaceFilter
+	NamePattern      *regexp.Regexp
+	Source           string
+	Selection        string
+	MonitorHostnames *MonitorHostnames
+	Bus              string
+	UnitPrefix       string
+	Resolver         *HostResolver
+	ConfigReader     io.Reader
+}
+
+// stdinInterfaceName names the interface of a config read from standard input
+// when --single-interface does not.
+const stdinInterfaceName = "stdin"
+
+type EndpointReport struct {
+	Interface      string `json:"interface"`
+	Endpoint       string `json:"endpoint"`
+	Hostname       string `json:"hostname"`
+	CurrentIP      string `json:"current_ip"`
+	IPv4           string `json:"ipv4,omitempty"`
+	IPv6           string `json:"ipv6,omitempty"`
+	BackupEndpoint string `json:"backup_endpoint,omitempty"`
+	ActiveEndpoint string `json:"active_endpoint,omitempty"`
+	Changed        *bool  `json:"changed,omitempty"`
+}
+
+func performCheckOnly(opts CheckOptions) {
+	var configs []Config
+
+	if opts.ConfigReader != nil {
+		interfaceName := opts.SingleInterface
+		if interfaceName == "" {
+			interfaceName = stdinInterfaceName
+		}
+		parsed, err := readWireGuardEndpoints(interfaceName, opts.ConfigReader, opts.Selection, opts.MonitorHostnames, opts.Resolver)
+		if err != nil {
+			checkOnlyFatal(opts, "Failed to parse config from standard input: %v", err)
+		}
+		configs = parsed
+		if opts.Output == outputText {
+			fmt.Printf("Checking config from standard input as interface: %s\n", interfaceName)
+		}
+	} else if opts.SingleInterface != "" {
+		configPath := filepath.Join("/etc/wireguard", opts.SingleInterface+".conf")
+		if err := parseWireGuardConfigForCheck(opts.SingleInterface, configPath, opts.Source, opts.Selection, opts.MonitorHostnames, opts.Resolver, &configs); err != nil {
+			checkOnlyFatal(opts, "Failed to parse config for %s: %v", opts.SingleInterface, err)
+		}
+		if opts.Output == outputText {
+			fmt.Printf("Checking single interface: %s\n", opts.SingleInterface)
+		}
+	} else {
+		conn, _, err := connectSystemd(context.Background(), opts.Bus)
+		if err != nil {
+			checkOnlyFatal(opts, "Failed to connect to systemd: %v", err)
+		}
+		defer conn.Close()
+
+		if err := discoverWireGuardConfigsForCheck(conn, opts.UnitPrefix, opts.Filter, opts.NamePattern, opts.Source, opts.Selection, opts.MonitorHostnames, opts.Resolver, &configs); err != nil {
+			checkOnlyFatal(opts, "Failed to discover WireGuard interfaces: %v", err)
+		}
+		if opts.Output == outputText {
+			fmt.Printf("Scanning all active WireGuard interfaces...\n")
+		}
+	}
+
+	reports := buildEndpointReports(opts, configs)
+
+	if opts.Output == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			checkOnlyFatal(opts, "Failed to encode output: %v", err)
+		}
+		return
+	}
+
+	if len(reports) == 0 {
+		fmt.Println("No active WireGuard interfaces with domain endpoints found.")
+		return
+	}
+
+	fmt.Printf("\nFound %d active WireGuard interface(s) with domain endpoints:\n\n", len(reports))
+
+	for i, report := range reports {
+		fmt.Printf("%d. Interface: %s\n", i+1, report.Interface)
+		fmt.Printf("   Endpoint: %s\n", report.Endpoint)
+		fmt.Printf("   Hostname: %s\n", report.Hostname)
+		if report.CurrentIP != "" {
+			fmt.Printf("   Current IP: %s\n", report.CurrentIP)
+		} else {
+			fmt.Printf("   Current IP: (failed to resolve)\n")
+		}
+		if opts.Resolver.family == familyDual {
+			fmt.Printf("   IPv4: %s\n", report.IPv4)
+			fmt.Printf("   IPv6: %s\n", report.IPv6)
+		}
+		if report.BackupEndpoint != "" {
+			fmt.Printf("   Backup: %s\n", report.BackupEndpoint)
+		}
+		if opts.Command == commandCheck {
+			if report.ActiveEndpoint != "" {
+				fmt.Printf("   Active Endpoint: %s\n", report.ActiveEndpoint)
+			} else {
+				fmt.Printf("   Active Endpoint: (unknown)\n")
+			}
+			if report.Changed != nil && *report.Changed {
+				fmt.Printf("   Status: CHANGED\n")
+			} else if report.Changed != nil {
+				fmt.Printf("   Status: up to date\n")
+			}
+		}
+		fmt.Println()
+	}
+}
+
+func buildEndpointReports(opts CheckOptions, configs []Config) []EndpointReport {
+	live := make(map[string]map[string]string)
+	reports := make([]EndpointReport, 0, len(configs))
+
+	for _, config := range configs {
+		report := EndpointReport{
+			Interface: config.Interface,
+			Endpoint:  config.Endpoint,
+			Hostname:  config.Hostname,
+			CurrentIP: ipString(config.LastIP),
+			IPv4:      ipString(config.LastIPv4),
+			IPv6:      ipString(config.LastIPv6),
+		}
+		if config.BackupHostname != "" {
+			report.BackupEndpoint = net.JoinHostPort(config.BackupHostname, config.BackupPort)
+		}
+
+		if opts.Command == commandCheck {
+			endpoints, ok := live[config.Interface]
+			if !ok {
+				endpoints, _ = readLivePeerEndpoints(config.Interface)
+				live[config.Interface] = endpoints
+			}
+
+			report.ActiveEndpoint = endpoints[config.PublicKey]
+			if report.ActiveEndpoint != "" && config.LastIP != nil {
+				changed := report.ActiveEndpoint != config.CurrentEndpoint()
+				report.Changed = &changed
+			}
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports
+}
+
+type SelfTestResult struct {
+	Check    string `json:"check"`
+	Passed   bool   `json:"passed"`
+	Critical bool   `json:"critical"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// performSelfTest verifies that the daemon could run with the given options
+// and reports whether every critical check passed.
+func performSelfTest(opts CheckOptions) bool {
+	var results []SelfTestResult
+	record := func(check string, critical bool, err error, detail string) {
+		result := SelfTestResult{Check: check, Passed: err == nil, Critical: critical, Detail: detail}
+		if err != nil {
+			result.Detail = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	conn, bus, err := connectSystemd(context.Background(), opts.Bus)
+	record("systemd D-Bus connection", true, err, fmt.Sprintf("connected to the %s bus", bus))
+	if conn != nil {
+		defer conn.Close()
+	}
+
+	_, err = os.ReadDir("/etc/wireguard")
+	record("config directory /etc/wireguard readable", true, err, "readable")
+
+	var interfaces []string
+	if opts.SingleInterface != "" {
+		interfaces = []string{opts.SingleInterface}
+	} else if conn != nil {
+		active, err := listActiveWireGuardInterfaces(conn, opts.UnitPrefix)
+		record("active interface discovery", true, err, fmt.Sprintf("%d active %s unit(s)", len(active), opts.UnitPrefix))
+		for _, interfaceName := range active {
+			if matchesNamePattern(opts.NamePattern, interfaceName) && opts.Filter.Match(interfaceName) {
+				interfaces = append(interfaces, interfaceName)
+			}
+		}
+	}
+
+	for _, interfaceName := range interfaces {
+		configPath := filepath.Join("/etc/wireguard", interfaceName+".conf")
+		configs, err := readWireGuardConfig(interfaceName, configPath, opts.Selection, opts.MonitorHostnames)
+		record(fmt.Sprintf("config %s", configPath), !isPartialRead(err), err, fmt.Sprintf("%d domain endpoint(s)", len(configs)))
+
+		for _, config := range configs {
+			result, err := opts.Resolver.ResolveFamily(context.Background(), config.Hostname, config.Family)
+			detail := ""
+			if err == nil {
+				detail = ipString(result.Chosen)
+			}
+			record(fmt.Sprintf("resolve %s (interface: %s)", config.Hostname, interfaceName), true, err, detail)
+		}
+
+		if conn != nil {
+			serviceName := unitName(opts.UnitPrefix, interfaceName)
+			property, err := conn.GetUnitPropertyContext(context.Background(), serviceName, "LoadState")
+			detail := ""
+			if err == nil {
+				loadState, _ := property.Value.Value().(string)
+				detail = loadState
+				if loadState != "loaded" {
+					err = fmt.Errorf("unit is %s", loadState)
+				}
+			}
+			record(fmt.Sprintf("unit %s", serviceName), false, err, detail)
+		}
+	}
+
+	passed := true
+	for _, result := range results {
+		if result.Critical && !result.Passed {
+			passed = false
+		}
+	}
+
+	if opts.Output == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(map[string]interface{}{
+			"passed":  passed,
+			"results": results,
+		})
+		return passed
+	}
+
+	for _, result := range results {
+		status := "PASS"
+		if !result.Passed && result.Critical {
+			status = "FAIL"
+		} else if !result.Passed {
+			status = "WARN"
+		}
+		fmt.Printf("[%s] %s: %s\n", status, result.Check, result.Detail)
+	}
+	if passed {
+		fmt.Println("\nSelf-test passed")
+	} else {
+		fmt.Println("\nSelf-test failed")
+	}
+	return passed
+}
+
+func checkOnlyFatal(opts CheckOptions, format string, args ...interface{}) {
+	if opts.Output == outputJSON {
+		fmt.Fprintf(os.Stderr, "Error: "+format+"\n", args...)
+	} else {
+		fmt.Printf("Error: "+format+"\n", args...)
+	}
+	os.Exit(1)
+}
+
+func discoverWireGuardConfigsForCheck(conn *dbus.Conn, unitPrefix string, filter *InterfaceFilter, namePattern *regexp.Regexp, source, selection string, monitorHostnames *MonitorHostnames, resolver *HostResolver, configs *[]Config) error {
+	interfaces, err := listActiveWireGuardInterfaces(conn, unitPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, interfaceName := range interfaces {
+		if !matchesNamePattern(namePattern, interfaceName) || !filter.Match(interfaceName) {
+			continue
+		}
+
+		configPath := filepath.Join("/etc/wireguard", interfaceName+".conf")
+		if err := parseWireGuardConfigForCheck(interfaceName, configPath, source, selection, monitorHostnames, resolver, configs); err != nil {
+			continue
+		}
+	}
+
+	return nil
+}
+
+const (
+	systemdBusSystem = "system"
+	systemdBusUser   = "user"
+	systemdBusAuto   = "auto"
+)
+
+// connectSystemd connects to the system or user instance of systemd and
+// returns the bus that was used. The system instance is reached through
+// dbus.NewWithContext, which uses systemd's private socket when available and
+// the system bus otherwise.
+func connectSystemd(ctx context.Context, bus string) (*dbus.Conn, string, error) {
+	switch bus {
+	case systemdBusUser:
+		conn, err := dbus.NewUserConnectionContext(ctx)
+		return conn, systemdBusUser, err
+	case systemdBusAuto:
+		conn, err := dbus.NewWithContext(ctx)
+		if err == nil {
+			return conn, systemdBusSystem, nil
+		}
+		userConn, userErr := dbus.NewUserConnectionContext(ctx)
+		if userErr != nil {
+			return nil, "", fmt.Errorf("system bus: %v, user bus: %w", err, userErr)
+		}
+		return userConn, systemdBusUser, nil
+	default:
+		conn, err := dbus.NewWithContext(ctx)
+		return conn, systemdBusSystem, err
+	}
+}
+
+const defaultUnitPrefix = "wg-quick@"
+
+// validateUnitPrefix checks that prefix names a template unit, so that the
+// instance name following it is the interface name.
+func validateUnitPrefix(prefix string) error {
+	if !strings.HasSuffix(prefix, "@") {
+		return fmt.Errorf("must end with '@' to name a template unit, e.g. wireguard@")
+	}
+	if prefix == "@" || strings.ContainsAny(prefix, "/ \t") {
+		return fmt.Errorf("not a valid unit name prefix")
+	}
+	return nil
+}
+
+// maxInterfaceNameLength is IFNAMSIZ without the terminating NUL.
+const maxInterfaceNameLength = 15
+
+// defaultInterfaceNamePattern is --interface-name-pattern when unset, the
+// names wg-quick accepts.
+const defaultInterfaceNamePattern = `^[A-Za-z0-9_=+.-]{1,15}$`
+
+// validateInterfaceName checks that name is an interface name wg-quick would
+// accept. Interface names end up in unit names, config paths and wg(8)
+// arguments, so a name read from a crafted config filename or sent to the
+// API is refused before it gets there.
+func validateInterfaceName(name string) error {
+	if name == "" || len(name) > maxInterfaceNameLength {
+		return fmt.Errorf("invalid interface name %q: must be 1 to %d characters long", name, maxInterfaceNameLength)
+	}
+	if name[0] == '-' || name == "." || name == ".." {
+		return fmt.Errorf("invalid interface name %q", name)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_=+.-", r)) {
+			return fmt.Errorf("invalid interface name %q: only letters, digits and _=+.- are allowed", name)
+		}
+	}
+	return nil
+}
+
+// matchesNamePattern reports whether name is allowed by the
+// --interface-name-pattern allowlist. A nil pattern allows every name.
+func matchesNamePattern(pattern *regexp.Regexp, name string) bool {
+	return pattern == nil || pattern.MatchString(name)
+}
+
+// checkInterfaceName validates name and checks it against the
+// --interface-name-pattern allowlist.
+func checkInterfaceName(pattern *regexp.Regexp, name string) error {
+	if err := validateInterfaceName(name); err != nil {
+		return err
+	}
+	if !matchesNamePattern(pattern, name) {
+		return fmt.Errorf("interface name %q does not match --interface-name-pattern", name)
+	}
+	return nil
+}
+
+func unitName(prefix, interfaceName string) string {
+	return prefix + interfaceName + ".service"
+}
+
+func (m *DDNSMonitor) unitName(interfaceName string) string {
+	return unitName(m.unitPrefix, interfaceName)
+}
+
+func listActiveWireGuardInterfaces(conn *dbus.Conn, unitPrefix string) ([]string, error) {
+	units, err := conn.ListUnitsContext(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list systemd units: %w", err)
+	}
+
+	var interfaces []string
+	for _, unit := range units {
+		if strings.HasPrefix(unit.Name, unitPrefix) && strings.HasSuffix(unit.Name, ".service") && unit.ActiveState == "active" {
+			interfaceName := strings.TrimPrefix(unit.Name, unitPrefix)
+			interfaceName = strings.TrimSuffix(interfaceName, ".service")
+			interfaces = append(interfaces, interfaceName)
+		}
+	}
+
+	return interfaces, nil
+}
+
+func parseWireGuardConfigForCheck(interfaceName, configPath, source, selection string, monitorHostnames *MonitorHostnames, resolver *HostResolver, configs *[]Config) error {
+	parsed, err := loadWireGuardEndpoints(interfaceName, configPath, source, selection, monitorHostnames, resolver)
+	*configs = append(*configs, parsed...)
+	if isPartialRead(err) {
+		fmt.Fprintf(os.Stderr, "Warning: %v, using the %d endpoint(s) parsed before the error\n", err, len(parsed))
+		return nil
+	}
+	return err
+}
+
+func parseFamilyAnnotation(value string) string {
+	switch strings.ToLower(value) {
+	case "ip4", "ipv4":
+		return familyIPv4
+	case "ip6", "ipv6":
+		return familyIPv6
+	case "dual":
+		return familyDual
+	case "any":
+		return familyAny
+	default:
+		return ""
+	}
+}
+
+func parseEndpointAnnotations(value string) (string, map[string]string) {
+	endpoint, comment, found := strings.Cut(value, "#")
+	endpoint = strings.TrimSpace(endpoint)
+	if !found {
+		return endpoint, nil
+	}
+
+	annotations := make(map[string]string)
+	for _, field := range strings.FieldsFunc(comment, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	}) {
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		annotations[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(val)
+	}
+
+	return endpoint, annotations
+}
+
+const (
+	maxEndpointLabels   = 8
+	maxLabelNameLength  = 32
+	maxLabelValueLength = 64
+)
+
+// cutLabelsDirective returns the value of a "# labels: key=value,..."
+// comment line.
+func cutLabelsDirective(comment string) (string, bool) {
+	key, value, found := strings.Cut(strings.TrimSpace(comment), ":")
+	if !found || !strings.EqualFold(strings.TrimSpace(key), "labels") {
+		return "", false
+	}
+	return value, true
+}
+
+// parseEndpointLabels parses the comma-separated key=value pairs of a labels
+// directive. The labels end up in metrics, so names must be valid Prometheus
+// label names other than the interface and hostname labels wg-ddns sets
+// itself, lengths are capped and only the first maxEndpointLabels are kept.
+// Pairs that do not qualify are dropped.
+func parseEndpointLabels(value string) map[string]string {
+	labels := make(map[string]string)
+	for _, field := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(field, "=")
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if !ok || !isLabelName(key) || key == "interface" || key == "hostname" ||
+			len(key) > maxLabelNameLength || len(val) > maxLabelValueLength {
+			continue
+		}
+		if _, exists := labels[key]; !exists && len(labels) >= maxEndpointLabels {
+			continue
+		}
+		labels[key] = val
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+func isLabelName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	endpointSourceFile     = "file"
+	endpointSourceShowconf = "showconf"
+)
+
+const (
+	endpointSelectionFirst = "first"
+	endpointSelectionLast  = "last"
+)
+
+const (
+	compareAddress = "address"
+	compareSet     = "set"
+)
+
+func openWireGuardConfig(configPath string) (*os.File, error) {
+	file, err := os.Open(configPath)
+	if errors.Is(err, fs.ErrPermission) {
+		return nil, fmt.Errorf("permission denied reading config file %s: run wg-ddns as root or grant it read access (e.g. CAP_DAC_READ_SEARCH): %w", configPath, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file %s: %w", configPath, err)
+	}
+	return file, nil
+}
+
+const maxIncludeDepth = 8
+
+// PartialReadError reports that reading a config file stopped early. The
+// endpoints parsed before the error are still returned alongside it, so
+// callers can keep monitoring them and only warn about the rest.
+type PartialReadError struct {
+	Path string
+	Err  error
+}
+
+func (e *PartialReadError) Error() string {
+	return fmt.Sprintf("config file %s was only partially read: %v", e.Path, e.Err)
+}
+
+func (e *PartialReadError) Unwrap() error {
+	return e.Err
+}
+
+func isPartialRead(err error) bool {
+	var partial *PartialReadError
+	return errors.As(err, &partial)
+}
+
+// readWireGuardConfig parses the endpoints of configPath together with those
+// of any fragments it pulls in with "# include <pattern>" directives.
+// Fragments must live below the directory of configPath and each file may
+// only be included once, which also rules out include loops. selection picks
+// the endpoint used for peers that list more than one.
+func readWireGuardConfig(interfaceName, configPath, selection string, monitorHostnames *MonitorHostnames) ([]Config, error) {
+	if err := validateInterfaceName(interfaceName); err != nil {
+		return nil, err
+	}
+	baseDir, err := filepath.EvalSymlinks(filepath.Dir(configPath))
+	if err != nil {
+		baseDir = filepath.Dir(configPath)
+	}
+	return readWireGuardConfigFile(interfaceName, configPath, selection, monitorHostnames, baseDir, make(map[string]bool), 0)
+}
+
+func readWireGuardConfigFile(interfaceName, path, selection string, monitorHostnames *MonitorHostnames, baseDir string, visited map[string]bool, depth int) ([]Config, error) {
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		realPath = path
+	}
+	if visited[realPath] {
+		return nil, fmt.Errorf("%s is included more than once", path)
+	}
+	visited[realPath] = true
+
+	file, err := openWireGuardConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+
+	var partial error
+	var r io.Reader = bytes.NewReader(data)
+	if err != nil {
+		if len(data) == 0 {
+			return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+		}
+		// Handing the error on lets the parser drop the section it was cut
+		// off in.
+		r = io.MultiReader(r, errReader{err})
+	}
+
+	configs, err := parseWireGuardEndpoints(interfaceName, r, selection, monitorHostnames)
+	if err != nil {
+		partial = &PartialReadError{Path: path, Err: err}
+	}
+
+	for _, pattern := range parseIncludeDirectives(data) {
+		if depth >= maxIncludeDepth {
+			return configs, fmt.Errorf("includes in %s are nested deeper than %d levels", path, maxIncludeDepth)
+		}
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return configs, fmt.Errorf("invalid include pattern '%s' in %s: %w", pattern, path, err)
+		}
+		for _, match := range matches {
+			if !isWithinDir(match, baseDir) {
+				return configs, fmt.Errorf("include %s in %s is outside %s", match, path, baseDir)
+			}
+			fragment, err := readWireGuardConfigFile(interfaceName, match, selection, monitorHostnames, baseDir, visited, depth+1)
+			configs = append(configs, fragment...)
+			if isPartialRead(err) {
+				if partial == nil {
+					partial = err
+				}
+				continue
+			}
+			if err != nil {
+				return configs, err
+			}
+		}
+	}
+
+	return configs, partial
+}
+
+// errReader fails every read with err.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func parseIncludeDirectives(data []byte) []string {
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		comment, found := strings.CutPrefix(strings.TrimSpace(line), "#")
+		if !found {
+			continue
+		}
+		fields := strings.Fields(comment)
+		if len(fields) == 2 && strings.EqualFold(fields[0], "include") {
+			patterns = append(patterns, fields[1])
+		}
+	}
+	return patterns
+}
+
+// isWithinDir reports whether path, with symlinks resolved, is below dir.
+func isWithinDir(path, dir string) bool {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, resolved)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+func loadWireGuardEndpoints(interfaceName, configPath, source, selection string, monitorHostnames *MonitorHostnames, resolver *HostResolver) ([]Config, error) {
+	configs, err := readWireGuardConfig(interfaceName, configPath, selection, monitorHostnames)
+	if (err == nil || isPartialRead(err)) && source == endpointSourceShowconf {
+		var filterErr error
+		configs, filterErr = filterLivePeers(interfaceName, configs)
+		if filterErr != nil {
+			err = filterErr
+		}
+	}
+
+	resolveLoadedEndpoints(configs, resolver)
+	return configs, err
+}
+
+// readWireGuardEndpoints parses and resolves the endpoints of a config read
+// from r rather than from /etc/wireguard. Include directives are ignored as
+// there is no directory to resolve them against.
+func readWireGuardEndpoints(interfaceName string, r io.Reader, selection string, monitorHostnames *MonitorHostnames, resolver *HostResolver) ([]Config, error) {
+	if err := validateInterfaceName(interfaceName); err != nil {
+		return nil, err
+	}
+	configs, err := parseWireGuardEndpoints(interfaceName, r, selection, monitorHostnames)
+	if err != nil {
+		return nil, err
+	}
+	resolveLoadedEndpoints(configs, resolver)
+	return configs, nil
+}
+
+func resolveLoadedEndpoints(configs []Config, resolver *HostResolver) {
+	for i := range configs {
+		if configs[i].LastIP != nil {
+			continue
+		}
+		if result, err := resolver.ResolveFamily(context.Background(), configs[i].Hostname, configs[i].Family); err == nil {
+			configs[i].LastIP = result.Chosen
+			configs[i].LastIPv4 = result.IPv4
+			configs[i].LastIPv6 = result.IPv6
+			configs[i].ResolvedIP = result.Chosen
+			configs[i].Addresses = result.Addresses
+			configs[i].FamilyReason = result.Reason
+			configs[i].Fingerprint = result.Fingerprint()
+		}
+	}
+}
+
+func filterLivePeers(interfaceName string, configs []Config) ([]Config, error) {
+	live, err := readLivePeerEndpoints(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	running := configs[:0]
+	for _, config := range configs {
+		endpoint, ok := live[config.PublicKey]
+		if !ok {
+			continue
+		}
+		if host, _, err := net.SplitHostPort(endpoint); err == nil {
+			if ip := net.ParseIP(host); ip != nil {
+				config.LastIP = normalizeIP(ip)
+			}
+		}
+		running = append(running, config)
+	}
+
+	return running, nil
+}
+
+func readLivePeerEndpoints(interfaceName string) (map[string]string, error) {
+	if err := validateInterfaceName(interfaceName); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "wg", "showconf", interfaceName).Output()
+	if err != nil {
+		return nil, fmt.Errorf("wg showconf %s failed: %w", interfaceName, err)
+	}
+
+	live := make(map[string]string)
+	var publicKey, endpoint string
+	flush := func() {
+		if publicKey != "" {
+			live[publicKey] = endpoint
+		}
+		publicKey, endpoint = "", ""
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			flush()
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "PublicKey":
+			publicKey = strings.TrimSpace(value)
+		case "Endpoint":
+			endpoint = strings.TrimSpace(value)
+		}
+	}
+	flush()
+
+	return live, scanner.Err()
+}
+
+func isIPLiteral(host string) bool {
+	host, _, _ = strings.Cut(host, "%")
+	return net.ParseIP(host) != nil
+}
+
+// parseWireGuardEndpoints returns the domain endpoints of the peers in r.
+// When a peer lists Endpoint more than once, selection decides whether the
+// first or, like wg itself, the last one is monitored. IP-literal endpoints
+// are tracked through their monitor= annotation or else monitorHostnames.
+func parseWireGuardEndpoints(interfaceName string, r io.Reader, selection string, monitorHostnames *MonitorHostnames) ([]Config, error) {
+	scanner := bufio.NewScanner(r)
+
+	var configs []Config
+	var section, publicKey string
+	var labels map[string]string
+	var sectionHasEndpoint bool
+	sectionStart := 0
+
+	finishSection := func() {
+		for i := sectionStart; i < len(configs); i++ {
+			configs[i].PublicKey = publicKey
+			configs[i].Labels = labels
+		}
+		publicKey = ""
+		labels = nil
+		sectionHasEndpoint = false
+		sectionStart = len(configs)
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if comment, found := strings.CutPrefix(line, "#"); found {
+			if value, ok := cutLabelsDirective(comment); ok && section == "peer" {
+				labels = parseEndpointLabels(value)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			finishSection()
+			header, _, _ := strings.Cut(line, "#")
+			section = strings.ToLower(strings.Trim(strings.TrimSpace(header), "[]"))
+			continue
+		}
+
+		if section != "peer" {
+			continue
+		}
+
+		rawKey, rawValue, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+
+		switch strings.ToLower(strings.TrimSpace(rawKey)) {
+		case "publickey":
+			value, _, _ := strings.Cut(rawValue, "#")
+			publicKey = strings.TrimSpace(value)
+		case "endpoint":
+			if sectionHasEndpoint {
+				if selection == endpointSelectionFirst {
+					continue
+				}
+				configs = configs[:sectionStart]
+			}
+			sectionHasEndpoint = true
+
+			endpoint, annotations := parseEndpointAnnotations(rawValue)
+
+			host, port, err := net.SplitHostPort(endpoint)
+			if err != nil || host == "" {
+				continue
+			}
+
+			config := Config{
+				Interface: interfaceName,
+				Endpoint:  endpoint,
+				Hostname:  host,
+				Port:      port,
+				Family:    parseFamilyAnnotation(annotations["family"]),
+			}
+
+			if isIPLiteral(host) {
+				monitor := annotations["monitor"]
+				if monitor == "" {
+					monitor = monitorHostnames.For(interfaceName)
+				}
+				if monitor == "" {
+					continue
+				}
+				config.Hostname = monitor
+				config.StaticIP = normalizeIP(net.ParseIP(host))
+			}
+
+			if backup := annotations["backup"]; backup != "" {
+				backupHost, backupPort, err := net.SplitHostPort(backup)
+				if err != nil {
+					backupHost, backupPort = backup, port
+				}
+				config.BackupHostname = backupHost
+				config.BackupPort = backupPort
+			}
+
+			configs = append(configs, config)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		// The section the error hit may be missing lines or end in a
+		// truncated one, such as an Endpoint with a cut-off host or port,
+		// so only the sections before it are returned.
+		return configs[:sectionStart], err
+	}
+	finishSection()
+
+	return configs, nil
 }
 
 // @title WireGuard DDNS API
@@ -349,394 +2963,3626 @@ func parseWireGuardConfigForCheck(interfaceName, configPath string, configs *[]C
 // @securityDefinitions.apikey ApiKeyAuth
 // @in header
 // @name X-API-Key
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
 func main() {
 	args := parseArgs()
 
-	if args.help {
-		printUsage()
-		os.Exit(0)
+	if args.help {
+		printUsage()
+		os.Exit(0)
+	}
+
+	if args.version {
+		printVersion()
+		os.Exit(0)
+	}
+
+	var interfaceFilter *InterfaceFilter
+	if args.interfaces != "" {
+		if args.singleInterface != "" {
+			fmt.Fprintf(os.Stderr, "Error: --interfaces cannot be used together with --single-interface\n")
+			os.Exit(1)
+		}
+
+		var err error
+		interfaceFilter, err = parseInterfaceFilter(args.interfaces)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid --interfaces value: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if args.interfaceNamePattern == "" {
+		args.interfaceNamePattern = defaultInterfaceNamePattern
+	}
+	namePattern, err := regexp.Compile(args.interfaceNamePattern)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Invalid --interface-name-pattern value: %v\n", err)
+		os.Exit(1)
+	}
+
+	if args.singleInterface != "" {
+		if err := checkInterfaceName(namePattern, args.singleInterface); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid --single-interface value: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	switch args.endpointSource {
+	case "":
+		args.endpointSource = endpointSourceFile
+	case endpointSourceFile, endpointSourceShowconf:
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Invalid --endpoint-source value '%s', must be 'file' or 'showconf'\n", args.endpointSource)
+		os.Exit(1)
+	}
+
+	switch args.endpointSelection {
+	case "":
+		args.endpointSelection = endpointSelectionLast
+	case endpointSelectionFirst, endpointSelectionLast:
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Invalid --endpoint-selection value '%s', must be 'first' or 'last'\n", args.endpointSelection)
+		os.Exit(1)
+	}
+
+	var monitorHostnames *MonitorHostnames
+	if args.monitorHostname != "" {
+		var err error
+		monitorHostnames, err = parseMonitorHostnames(args.monitorHostname)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid --monitor-hostname value: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	switch args.compareMode {
+	case "":
+		args.compareMode = compareAddress
+	case compareAddress, compareSet:
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Invalid --compare-mode value '%s', must be 'address' or 'set'\n", args.compareMode)
+		os.Exit(1)
+	}
+
+	switch args.systemdBus {
+	case "":
+		args.systemdBus = systemdBusSystem
+	case systemdBusSystem, systemdBusUser, systemdBusAuto:
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Invalid --systemd-bus value '%s', must be one of: system, user, auto\n", args.systemdBus)
+		os.Exit(1)
+	}
+
+	if args.unitPrefix == "" {
+		args.unitPrefix = defaultUnitPrefix
+	} else if err := validateUnitPrefix(args.unitPrefix); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Invalid --unit-prefix value '%s': %v\n", args.unitPrefix, err)
+		os.Exit(1)
+	}
+
+	if args.dnsServer != "" && args.dnsServersFile != "" {
+		fmt.Fprintf(os.Stderr, "Error: --dns-server cannot be used together with --dns-servers-file\n")
+		os.Exit(1)
+	}
+
+	var dnsServers []string
+	if args.dnsServersFile != "" {
+		dnsServers, err = readDNSServersFile(args.dnsServersFile)
+	} else {
+		dnsServers, err = parseDNSServers(args.dnsServer)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var apiKeyScopes map[string]*InterfaceFilter
+	if args.apiKeysFile != "" {
+		if args.apiKey == "" {
+			fmt.Fprintf(os.Stderr, "Error: --api-keys-file requires --api-key\n")
+			os.Exit(1)
+		}
+		apiKeyScopes, err = readAPIKeysFile(args.apiKeysFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if _, ok := apiKeyScopes[args.apiKey]; ok {
+			fmt.Fprintf(os.Stderr, "Error: --api-keys-file lists the key given with --api-key\n")
+			os.Exit(1)
+		}
+	}
+
+	if args.auditFile != "" && !args.auditOnly {
+		fmt.Fprintf(os.Stderr, "Error: --audit-file requires --audit-only\n")
+		os.Exit(1)
+	}
+
+	if args.dnssec && len(dnsServers) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: --dnssec requires a validating resolver set with --dns-server or --dns-servers-file\n")
+		os.Exit(1)
+	}
+	if args.dnssec {
+		if err := checkDNSSECServers(dnsServers); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if args.dnsProxy != "" {
+			fmt.Fprintf(os.Stderr, "Error: --dnssec cannot be used together with --dns-proxy, the AD bit could not be trusted across the proxy\n")
+			os.Exit(1)
+		}
+	}
+
+	var ecs []byte
+	if args.ecs != "" {
+		if len(dnsServers) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: --ecs requires --dns-server or --dns-servers-file\n")
+			os.Exit(1)
+		}
+		ecs, err = parseECS(args.ecs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var dnsProxy proxy.ContextDialer
+	if args.dnsProxy != "" {
+		dnsProxy, err = parseDNSProxy(args.dnsProxy)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if args.resolveSourceInterface != "" && args.resolveSourceIP != "" {
+		fmt.Fprintf(os.Stderr, "Error: --resolve-source-interface cannot be used together with --resolve-source-ip\n")
+		os.Exit(1)
+	}
+
+	var resolveSource *ResolveSource
+	if args.resolveSourceInterface != "" || args.resolveSourceIP != "" {
+		if dnsProxy != nil {
+			fmt.Fprintf(os.Stderr, "Error: --resolve-source-interface and --resolve-source-ip cannot be used together with --dns-proxy\n")
+			os.Exit(1)
+		}
+		resolveSource, err = parseResolveSource(args.resolveSourceInterface, args.resolveSourceIP)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	family := familyIPv4
+	switch args.family {
+	case "":
+	case familyIPv4, familyIPv6, familyDual, familyAny:
+		family = args.family
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Invalid --family value '%s', must be one of: ip4, ip6, dual, any\n", args.family)
+		os.Exit(1)
+	}
+
+	preferFamily := familyIPv4
+	switch args.preferFamily {
+	case "":
+	case familyIPv4, familyIPv6:
+		preferFamily = args.preferFamily
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Invalid --prefer-family value '%s', must be ip4 or ip6\n", args.preferFamily)
+		os.Exit(1)
+	}
+
+	var preferNets []*net.IPNet
+	if args.preferCIDR != "" {
+		var err error
+		preferNets, err = parsePreferCIDRs(args.preferCIDR)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid --prefer-cidr value: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	switch args.output {
+	case "":
+		args.output = outputText
+	case outputText, outputJSON:
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Invalid --output value '%s', must be 'text' or 'json'\n", args.output)
+		os.Exit(1)
+	}
+
+	if args.selftest {
+		if !performSelfTest(CheckOptions{
+			Output:           args.output,
+			SingleInterface:  args.singleInterface,
+			Filter:           interfaceFilter,
+			NamePattern:      namePattern,
+			Selection:        args.endpointSelection,
+			MonitorHostnames: monitorHostnames,
+			Bus:              args.systemdBus,
+			UnitPrefix:       args.unitPrefix,
+			Resolver: &HostResolver{
+				resolver:   newResolver(dnsServers, dnsProxy, resolveSource),
+				family:     family,
+				prefer:     preferFamily,
+				preferNets: preferNets,
+				dnssec:     args.dnssec,
+				ecs:        ecs,
+			},
+		}) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if args.configStdin && !args.checkOnly && args.command == "" {
+		fmt.Fprintf(os.Stderr, "Error: --config-stdin requires the list or check command\n")
+		os.Exit(1)
+	}
+
+	if args.checkOnly || args.command != "" {
+		command := args.command
+		if command == "" {
+			command = commandList
+		}
+		var configReader io.Reader
+		if args.configStdin {
+			configReader = os.Stdin
+		}
+		performCheckOnly(CheckOptions{
+			Command:          command,
+			Output:           args.output,
+			SingleInterface:  args.singleInterface,
+			Filter:           interfaceFilter,
+			NamePattern:      namePattern,
+			Source:           args.endpointSource,
+			Selection:        args.endpointSelection,
+			MonitorHostnames: monitorHostnames,
+			Bus:              args.systemdBus,
+			UnitPrefix:       args.unitPrefix,
+			Resolver: &HostResolver{
+				resolver:   newResolver(dnsServers, dnsProxy, resolveSource),
+				family:     family,
+				prefer:     preferFamily,
+				preferNets: preferNets,
+				dnssec:     args.dnssec,
+				ecs:        ecs,
+			},
+			ConfigReader: configReader,
+		})
+		os.Exit(0)
+	}
+
+	logLevel := INFO
+	if args.logLevel != "" {
+		logLevel = parseLogLevel(args.logLevel)
+	}
+
+	timestampFormat, err := parseTimestampFormat(args.logTimestampFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Invalid --log-timestamp-format: %v\n", err)
+		os.Exit(1)
+	}
+
+	logBufferSize := 500
+	if args.logBufferSize != "" {
+		logBufferSize, err = strconv.Atoi(args.logBufferSize)
+		if err != nil || logBufferSize < 0 {
+			fmt.Fprintf(os.Stderr, "Error: Invalid --log-buffer-size value '%s', must be a non-negative integer\n", args.logBufferSize)
+			os.Exit(1)
+		}
+	}
+
+	logger = &Logger{
+		level:           logLevel,
+		timestampFormat: timestampFormat,
+		color:           !args.noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout),
+	}
+
+	if logBufferSize > 0 {
+		logger.recent = newLogBuffer(logBufferSize)
+	}
+
+	if args.logSyslog {
+		writer, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "wg-ddns")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to connect to syslog: %v\n", err)
+			os.Exit(1)
+		}
+		defer writer.Close()
+		logger.syslog = writer
+	}
+
+	log.SetOutput(io.Discard)
+	gin.DefaultWriter = io.Discard
+	gin.DefaultErrorWriter = io.Discard
+
+	checkInterval := 10 * time.Second
+	if args.checkInterval != "" {
+		var err error
+		checkInterval, err = time.ParseDuration(args.checkInterval)
+		if err != nil {
+			logger.Error("Invalid check interval format: %v", err)
+			os.Exit(1)
+		}
+		if checkInterval < time.Second {
+			logger.Error("Check interval must be at least 1 second")
+			os.Exit(1)
+		}
+	}
+
+	minInterval, maxInterval := checkInterval, checkInterval
+	if args.minCheckInterval != "" {
+		var err error
+		minInterval, err = time.ParseDuration(args.minCheckInterval)
+		if err != nil || minInterval < time.Second || minInterval > checkInterval {
+			logger.Error("Minimum check interval must be at least 1 second and no longer than the check interval (%v)", checkInterval)
+			os.Exit(1)
+		}
+	}
+	if args.maxCheckInterval != "" {
+		var err error
+		maxInterval, err = time.ParseDuration(args.maxCheckInterval)
+		if err != nil || maxInterval < checkInterval {
+			logger.Error("Maximum check interval must be a duration no shorter than the check interval (%v)", checkInterval)
+			os.Exit(1)
+		}
+	}
+
+	var checkOffset time.Duration
+	if args.checkOffset != "" {
+		tick := checkInterval
+		if minInterval < maxInterval {
+			tick = minInterval
+		}
+		var err error
+		checkOffset, err = time.ParseDuration(args.checkOffset)
+		if err != nil || checkOffset < 0 || checkOffset >= tick {
+			logger.Error("Check offset must be a duration shorter than the check interval (%v)", tick)
+			os.Exit(1)
+		}
+	}
+
+	failoverAfter := 3
+	if args.failoverAfter != "" {
+		var err error
+		failoverAfter, err = strconv.Atoi(args.failoverAfter)
+		if err != nil || failoverAfter < 1 {
+			logger.Error("Failover threshold must be a positive integer")
+			os.Exit(1)
+		}
+	}
+
+	switch args.failAction {
+	case "":
+		args.failAction = failActionIgnore
+	case failActionIgnore, failActionNotify, failActionRestart:
+	default:
+		logger.Error("Invalid fail action '%s', must be one of: ignore, notify, restart", args.failAction)
+		os.Exit(1)
+	}
+
+	failThreshold := 3
+	if args.failThreshold != "" {
+		var err error
+		failThreshold, err = strconv.Atoi(args.failThreshold)
+		if err != nil || failThreshold < 1 {
+			logger.Error("Fail threshold must be a positive integer")
+			os.Exit(1)
+		}
+	}
+
+	transientFailThreshold := 10
+	if args.transientFailThreshold != "" {
+		var err error
+		transientFailThreshold, err = strconv.Atoi(args.transientFailThreshold)
+		if err != nil || transientFailThreshold < 1 {
+			logger.Error("Transient fail threshold must be a positive integer")
+			os.Exit(1)
+		}
+	}
+
+	maxRestartsPerHour := 0
+	if args.maxRestartsPerHour != "" {
+		var err error
+		maxRestartsPerHour, err = strconv.Atoi(args.maxRestartsPerHour)
+		if err != nil || maxRestartsPerHour < 1 {
+			logger.Error("Max restarts per hour must be a positive integer")
+			os.Exit(1)
+		}
+	}
+
+	var manualCooldown time.Duration
+	if args.manualCooldown != "" {
+		var err error
+		manualCooldown, err = time.ParseDuration(args.manualCooldown)
+		if err != nil || manualCooldown < 0 {
+			logger.Error("Invalid manual restart cooldown: %s", args.manualCooldown)
+			os.Exit(1)
+		}
+	}
+
+	var startupGrace time.Duration
+	if args.startupGrace != "" {
+		var err error
+		startupGrace, err = time.ParseDuration(args.startupGrace)
+		if err != nil || startupGrace < 0 {
+			logger.Error("Invalid startup grace period: %s", args.startupGrace)
+			os.Exit(1)
+		}
+	}
+
+	var waitForConfig time.Duration
+	if args.waitForConfig != "" {
+		var err error
+		waitForConfig, err = time.ParseDuration(args.waitForConfig)
+		if err != nil || waitForConfig < 0 {
+			logger.Error("Invalid config wait timeout: %s", args.waitForConfig)
+			os.Exit(1)
+		}
+		if args.singleInterface == "" {
+			logger.Error("--wait-for-config requires --single-interface")
+			os.Exit(1)
+		}
+	}
+
+	var coalesceWindow time.Duration
+	if args.coalesceWindow != "" {
+		var err error
+		coalesceWindow, err = time.ParseDuration(args.coalesceWindow)
+		if err != nil || coalesceWindow < 0 {
+			logger.Error("Invalid coalesce window: %s", args.coalesceWindow)
+			os.Exit(1)
+		}
+	}
+
+	var dnsCache *DNSCache
+	if args.dnsCacheTTL != "" {
+		dnsCacheTTL, err := time.ParseDuration(args.dnsCacheTTL)
+		if err != nil || dnsCacheTTL < 0 {
+			logger.Error("Invalid DNS cache TTL: %s", args.dnsCacheTTL)
+			os.Exit(1)
+		}
+		if dnsCacheTTL > 0 {
+			dnsCache = newDNSCache(dnsCacheTTL)
+		}
+	}
+
+	switch args.restartMode {
+	case "":
+		args.restartMode = restartModeRestart
+	case restartModeRestart, restartModeReload, restartModeReloadOrRestart:
+	default:
+		logger.Error("Invalid restart mode '%s', must be one of: restart, reload, reload-or-restart", args.restartMode)
+		os.Exit(1)
+	}
+
+	var changeWindowHits, changeWindowSize int
+	if args.changeWindow != "" {
+		var err error
+		changeWindowHits, changeWindowSize, err = parseChangeWindow(args.changeWindow)
+		if err != nil {
+			logger.Error("Invalid change window: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if args.notifyBatch && args.notifyExec == "" {
+		logger.Error("--notify-batch requires --notify-exec")
+		os.Exit(1)
+	}
+
+	var notifyCommand []*template.Template
+	if args.notifyExec != "" {
+		var sample any = ChangeEvent{}
+		if args.notifyBatch {
+			sample = ChangeBatch{}
+		}
+		var err error
+		notifyCommand, err = parseNotifyCommand(args.notifyExec, sample)
+		if err != nil {
+			logger.Error("Invalid notify command: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	var audit *AuditLog
+	if args.auditFile != "" {
+		var err error
+		audit, err = openAuditLog(args.auditFile)
+		if err != nil {
+			logger.Error("%v", err)
+			os.Exit(1)
+		}
+	}
+
+	maxBodySize := int64(4096)
+	if args.maxBodySize != "" {
+		var err error
+		maxBodySize, err = strconv.ParseInt(args.maxBodySize, 10, 64)
+		if err != nil || maxBodySize < 1 {
+			logger.Error("Maximum body size must be a positive integer")
+			os.Exit(1)
+		}
+	}
+
+	apiEnabled := args.listenAddress != "" && args.listenPort != "" && args.apiKey != ""
+	if args.startInMaintenance && !apiEnabled {
+		logger.Error("--start-in-maintenance requires the API, the only way to leave maintenance mode")
+		os.Exit(1)
+	}
+
+	if apiEnabled {
+		if weakness := apiKeyWeakness(args.apiKey); weakness != "" {
+			if args.requireStrong {
+				logger.Error("API key is too weak: %s", weakness)
+				os.Exit(1)
+			}
+			logger.Warn("API key is weak: %s", weakness)
+		}
+		for key, scope := range apiKeyScopes {
+			if weakness := apiKeyWeakness(key); weakness != "" {
+				if args.requireStrong {
+					logger.Error("API key for %s is too weak: %s", scope, weakness)
+					os.Exit(1)
+				}
+				logger.Warn("API key for %s is weak: %s", scope, weakness)
+			}
+		}
+	}
+
+	monitor := &DDNSMonitor{
+		singleInterface:        args.singleInterface,
+		interfaceFilter:        interfaceFilter,
+		namePattern:            namePattern,
+		apiEnabled:             apiEnabled,
+		listenAddress:          args.listenAddress,
+		listenPort:             args.listenPort,
+		apiKey:                 args.apiKey,
+		apiKeyScopes:           apiKeyScopes,
+		checkInterval:          checkInterval,
+		minInterval:            minInterval,
+		maxInterval:            maxInterval,
+		failoverAfter:          failoverAfter,
+		failAction:             args.failAction,
+		failThreshold:          failThreshold,
+		transientFailThreshold: transientFailThreshold,
+		maxRestartsHour:        maxRestartsPerHour,
+		manualCooldown:         manualCooldown,
+		startupGrace:           startupGrace,
+		waitForConfig:          waitForConfig,
+		checkOffset:            checkOffset,
+		coalesceWindow:         coalesceWindow,
+		restartMode:            args.restartMode,
+		systemdBus:             args.systemdBus,
+		unitPrefix:             args.unitPrefix,
+		changeWindowHits:       changeWindowHits,
+		changeWindowSize:       changeWindowSize,
+		stateFile:              args.stateFile,
+		notifyCommand:          notifyCommand,
+		notifyBatch:            args.notifyBatch,
+		auditOnly:              args.auditOnly,
+		audit:                  audit,
+		maxBodySize:            maxBodySize,
+		endpointSource:         args.endpointSource,
+		endpointSelection:      args.endpointSelection,
+		monitorHostnames:       monitorHostnames,
+		compareMode:            args.compareMode,
+		dnsServers:             dnsServers,
+		dnsServersFile:         args.dnsServersFile,
+		dnsProxy:               dnsProxy,
+		dnssec:                 args.dnssec,
+		ecs:                    ecs,
+		resolveSource:          resolveSource,
+		family:                 family,
+		preferFamily:           preferFamily,
+		preferNets:             preferNets,
+		disableSwagger:         args.disableSwagger,
+		disableGzip:            args.disableGzip,
+		dnsCache:               dnsCache,
+		dashboard:              args.dashboard,
+	}
+	monitor.maintenance.Store(args.startInMaintenance)
+
+	monitor.resolver.Store(&HostResolver{
+		resolver:   newResolver(dnsServers, dnsProxy, resolveSource),
+		family:     family,
+		prefer:     preferFamily,
+		preferNets: preferNets,
+		dnssec:     args.dnssec,
+		ecs:        ecs,
+		cache:      dnsCache,
+	})
+	if dnsCache != nil {
+		logger.Info("Caching DNS lookups for at least %v", dnsCache.minTTL)
+	}
+	if len(dnsServers) > 0 {
+		logger.Info("Using DNS servers: %s", strings.Join(dnsServers, ", "))
+	}
+	if dnsProxy != nil {
+		logger.Info("Resolving DNS over TCP through proxy %s", args.dnsProxy)
+	}
+	if resolveSource != nil {
+		logger.Info("Sending DNS queries from %s (ipv4: %s, ipv6: %s)", resolveSource.name, ipString(resolveSource.ipv4), ipString(resolveSource.ipv6))
+	}
+	logger.Info("Monitoring the %s endpoint of peers that list more than one", args.endpointSelection)
+	if family == familyDual {
+		logger.Info("Tracking IPv4 and IPv6 addresses, preferring %s", preferFamily)
+	} else if family == familyIPv6 {
+		logger.Info("Tracking IPv6 addresses")
+	} else if family == familyAny {
+		logger.Info("Tracking the address preferred by the system's address selection")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		logger.Info("Received shutdown signal")
+		cancel()
+	}()
+
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
+	go func() {
+		for range hupChan {
+			logger.Info("Received SIGHUP, reloading DNS resolver")
+			monitor.reloadResolver()
+		}
+	}()
+
+	// Signals are handled before initializing, which may wait for
+	// --wait-for-config, so that the daemon can be stopped meanwhile.
+	if err := monitor.initialize(ctx); err != nil {
+		if ctx.Err() != nil {
+			logger.Info("Shutting down monitor")
+			monitor.cleanup()
+			return
+		}
+		logger.Error("Failed to initialize monitor: %v", err)
+		os.Exit(1)
+	}
+	defer monitor.cleanup()
+
+	if args.once {
+		code := monitor.runOnce(ctx)
+		monitor.cleanup()
+		os.Exit(code)
+	}
+
+	if monitor.apiEnabled {
+		go monitor.startHTTPServer(ctx)
+	}
+
+	logger.Info("WireGuard DDNS monitor started")
+	monitor.run(ctx)
+}
+
+func (m *DDNSMonitor) initialize(ctx context.Context) error {
+	conn, bus, err := connectSystemd(context.Background(), m.systemdBus)
+	if err != nil {
+		return fmt.Errorf("failed to connect to systemd: %w", err)
+	}
+	m.conn = conn
+	logger.Info("Connected to systemd over the %s bus", bus)
+
+	if m.singleInterface != "" {
+		err = m.parseSingleInterface(ctx)
+	} else {
+		err = m.discoverWireGuardConfigs()
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := m.restoreState(); err != nil {
+		return err
+	}
+	m.publishConfigs()
+	m.updateReadiness()
+	return nil
+}
+
+// publishConfigs makes a copy of the working configs available to readers
+// outside the check cycle. It must be called with cycleMu held. m.configs is
+// only touched with cycleMu held or before the monitor starts, so the API
+// never sees a half-updated entry. Check cycles and rediscovery are further
+// serialized by checkMu, which is the only lock they hold across DNS lookups,
+// the coalesce window and systemd calls.
+func (m *DDNSMonitor) publishConfigs() {
+	configs := slices.Clone(m.configs)
+	m.published.Store(&configs)
+}
+
+// beginCycle copies m.configs into m.work for a check cycle to update without
+// holding cycleMu. It must be called with checkMu held.
+func (m *DDNSMonitor) beginCycle() {
+	m.cycleMu.Lock()
+	defer m.cycleMu.Unlock()
+	m.work = slices.Clone(m.configs)
+	m.workBase = slices.Clone(m.configs)
+}
+
+// commitCycle writes m.work back to m.configs and publishes it. Disabled and
+// PortOverride keep the value the API has set meanwhile, and the change
+// statistics only gain what the cycle counted since the last commit, so a
+// reset during the cycle is not undone.
+func (m *DDNSMonitor) commitCycle() {
+	m.cycleMu.Lock()
+	defer m.cycleMu.Unlock()
+	for i := range m.work {
+		current, base := &m.configs[i], &m.workBase[i]
+		config := m.work[i]
+		config.Disabled = current.Disabled
+		config.PortOverride = current.PortOverride
+		config.ChangeCount = current.ChangeCount + config.ChangeCount - base.ChangeCount
+		if config.LastChangeAt.Equal(base.LastChangeAt) {
+			config.LastChangeAt = current.LastChangeAt
+		}
+		*current = config
+		m.work[i] = config
+		*base = config
+	}
+	m.publishConfigs()
+}
+
+// snapshot returns the configs last published by publishConfigs. The entries
+// must not be modified.
+func (m *DDNSMonitor) snapshot() []Config {
+	if configs := m.published.Load(); configs != nil {
+		return *configs
+	}
+	return nil
+}
+
+// unresolvedEndpoints lists the monitored hostnames that have not resolved
+// successfully since startup.
+func (m *DDNSMonitor) unresolvedEndpoints() []string {
+	var pending []string
+	for _, config := range m.snapshot() {
+		if !config.Disabled && config.ResolvedIP == nil {
+			pending = append(pending, config.Hostname)
+		}
+	}
+	return pending
+}
+
+// updateReadiness marks the monitor ready for /readyz once every monitored
+// endpoint has been resolved at least once. Readiness is never withdrawn
+// afterwards, later failures are reported through the logs and the API.
+func (m *DDNSMonitor) updateReadiness() {
+	if m.ready.Load() || len(m.unresolvedEndpoints()) > 0 {
+		return
+	}
+	m.ready.Store(true)
+	logger.Info("All %d endpoint(s) resolved, reporting ready", len(m.snapshot()))
+}
+
+func (m *DDNSMonitor) restoreState() error {
+	if m.stateFile == "" {
+		return nil
+	}
+
+	state, err := loadState(m.stateFile)
+	if err != nil {
+		return err
+	}
+
+	m.cycleMu.Lock()
+	defer m.cycleMu.Unlock()
+	for _, key := range state.Disabled {
+		for i := range m.configs {
+			config := &m.configs[i]
+			if config.Interface == key.Interface && config.Hostname == key.Hostname {
+				config.Disabled = true
+				logger.Info("Monitoring of %s is disabled (interface: %s)", config.Hostname, config.Interface)
+			}
+		}
+	}
+	for _, override := range state.PortOverrides {
+		for i := range m.configs {
+			config := &m.configs[i]
+			if config.Interface == override.Interface && config.Hostname == override.Hostname {
+				config.PortOverride = override.Port
+				logger.Info("Endpoint %s is updated with port override %s (interface: %s)", config.Hostname, override.Port, config.Interface)
+			}
+		}
+	}
+	if state.Maintenance {
+		m.maintenance.Store(true)
+	}
+	return nil
+}
+
+func (m *DDNSMonitor) persistState() error {
+	if m.stateFile == "" {
+		return nil
+	}
+
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	// Entries of endpoints that are not loaded right now, say of an
+	// interface that is down or filtered out for a while, are carried over
+	// so they apply again once the endpoint comes back.
+	previous, err := loadState(m.stateFile)
+	if err != nil {
+		return err
+	}
+	configs := m.snapshot()
+	loaded := make(map[EndpointKey]bool, len(configs))
+	for _, config := range configs {
+		loaded[EndpointKey{Interface: config.Interface, Hostname: config.Hostname}] = true
+	}
+
+	state := &State{Maintenance: m.maintenance.Load()}
+	for _, key := range previous.Disabled {
+		if !loaded[key] {
+			state.Disabled = append(state.Disabled, key)
+		}
+	}
+	for _, override := range previous.PortOverrides {
+		if !loaded[EndpointKey{Interface: override.Interface, Hostname: override.Hostname}] {
+			state.PortOverrides = append(state.PortOverrides, override)
+		}
+	}
+	for _, config := range configs {
+		if config.Disabled {
+			state.Disabled = append(state.Disabled, EndpointKey{Interface: config.Interface, Hostname: config.Hostname})
+		}
+		if config.PortOverride != "" {
+			state.PortOverrides = append(state.PortOverrides, PortOverride{
+				Interface: config.Interface,
+				Hostname:  config.Hostname,
+				Port:      config.PortOverride,
+			})
+		}
+	}
+	return saveState(m.stateFile, state)
+}
+
+func (m *DDNSMonitor) reloadResolver() {
+	servers := m.dnsServers
+	if m.dnsServersFile != "" {
+		var err error
+		servers, err = readDNSServersFile(m.dnsServersFile)
+		if err != nil {
+			logger.Error("Failed to reload DNS resolver, keeping previous one: %v", err)
+			return
+		}
+	}
+	if len(servers) == 0 && (m.dnssec || m.ecs != nil) {
+		logger.Error("Failed to reload DNS resolver, keeping previous one: %s lists no DNS servers, which --dnssec and --ecs require", m.dnsServersFile)
+		return
+	}
+	if m.dnssec {
+		if err := checkDNSSECServers(servers); err != nil {
+			logger.Error("Failed to reload DNS resolver, keeping previous one: %v", err)
+			return
+		}
+	}
+
+	if m.dnsCache != nil {
+		m.dnsCache.Flush()
+	}
+	m.resolver.Store(&HostResolver{
+		resolver:   newResolver(servers, m.dnsProxy, m.resolveSource),
+		family:     m.family,
+		prefer:     m.preferFamily,
+		preferNets: m.preferNets,
+		dnssec:     m.dnssec,
+		ecs:        m.ecs,
+		cache:      m.dnsCache,
+	})
+	if len(servers) > 0 {
+		logger.Info("DNS resolver reloaded, using DNS servers: %s", strings.Join(servers, ", "))
+	} else {
+		logger.Info("DNS resolver reloaded, using system resolver")
+	}
+}
+
+func (m *DDNSMonitor) resolve(ctx context.Context, host, family string) (*Resolution, error) {
+	return m.resolver.Load().ResolveFamily(ctx, host, family)
+}
+
+func (m *DDNSMonitor) familyOf(config *Config) string {
+	if config.Family != "" {
+		return config.Family
+	}
+	return m.family
+}
+
+func (m *DDNSMonitor) parseSingleInterface(ctx context.Context) error {
+	configPath := filepath.Join("/etc/wireguard", m.singleInterface+".conf")
+	if m.waitForConfig > 0 {
+		if err := waitForConfigFile(ctx, configPath, m.waitForConfig); err != nil {
+			return err
+		}
+	}
+	if err := m.parseWireGuardConfig(m.singleInterface, configPath); err != nil {
+		return fmt.Errorf("failed to parse config for %s: %w", m.singleInterface, err)
+	}
+
+	logger.Info("Monitoring single interface: %s with %d domain endpoints", m.singleInterface, len(m.configs))
+	for _, config := range m.configs {
+		logger.Info("Monitoring endpoint %s (interface: %s)", config.Endpoint, config.Interface)
+	}
+	return nil
+}
+
+func (m *DDNSMonitor) cleanup() {
+	m.notifyWG.Wait()
+	if m.httpServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		m.httpServer.Shutdown(shutdownCtx)
+	}
+	if conn := m.systemd(); conn != nil {
+		conn.Close()
+	}
+	if m.audit != nil {
+		m.audit.Close()
+	}
+}
+
+const configPollInterval = time.Second
+
+// waitForConfigFile waits up to timeout for path to exist, for boot orderings
+// where the daemon starts before the interface config is written. Errors
+// other than a missing file are left for parsing to report. The wait ends
+// early when ctx is cancelled.
+func waitForConfigFile(ctx context.Context, path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for waited := false; ; waited = true {
+		if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			if waited {
+				logger.Info("Config file %s appeared", path)
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("config file %s did not appear within %v", path, timeout)
+		}
+		if !waited {
+			logger.Info("Waiting up to %v for config file %s to appear", timeout, path)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("stopped waiting for config file %s: %w", path, ctx.Err())
+		case <-time.After(configPollInterval):
+		}
+	}
+}
+
+func (m *DDNSMonitor) discoverWireGuardConfigs() error {
+	interfaces, err := listActiveWireGuardInterfaces(m.systemd(), m.unitPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, interfaceName := range interfaces {
+		if !matchesNamePattern(m.namePattern, interfaceName) {
+			logger.Warn("Skipping interface %s: does not match --interface-name-pattern", interfaceName)
+			continue
+		}
+		if !m.interfaceFilter.Match(interfaceName) {
+			logger.Debug("Skipping interface %s: does not match interface filter", interfaceName)
+			continue
+		}
+
+		configPath := filepath.Join("/etc/wireguard", interfaceName+".conf")
+		if err := m.parseWireGuardConfig(interfaceName, configPath); err != nil {
+			if errors.Is(err, fs.ErrPermission) {
+				logger.Error("Failed to parse config for %s: %v", interfaceName, err)
+			} else {
+				logger.Warn("Failed to parse config for %s: %v", interfaceName, err)
+			}
+			continue
+		}
+	}
+
+	logger.Info("Discovered %d WireGuard interfaces with domain endpoints", len(m.configs))
+	return nil
+}
+
+// rediscover lists the active interfaces again, starts monitoring the new
+// ones and stops monitoring those that went away. Interfaces that are still
+// active keep their configs, including the last known IPs.
+func (m *DDNSMonitor) rediscover() (added, removed []string, err error) {
+	m.checkMu.Lock()
+	defer m.checkMu.Unlock()
+
+	interfaces, err := listActiveWireGuardInterfaces(m.systemd(), m.unitPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	active := make(map[string]bool)
+	for _, interfaceName := range interfaces {
+		if matchesNamePattern(m.namePattern, interfaceName) && m.interfaceFilter.Match(interfaceName) {
+			active[interfaceName] = true
+		}
+	}
+
+	m.cycleMu.Lock()
+	monitored := make(map[string]bool)
+	kept := make([]Config, 0, len(m.configs))
+	for _, config := range m.configs {
+		if !active[config.Interface] {
+			if !monitored[config.Interface] {
+				monitored[config.Interface] = true
+				removed = append(removed, config.Interface)
+				delete(m.deferredRestarts, config.Interface)
+				logger.Info("Interface %s is no longer active, stopped monitoring it", config.Interface)
+			}
+			continue
+		}
+		monitored[config.Interface] = true
+		kept = append(kept, config)
+	}
+	m.configs = kept
+	m.cycleMu.Unlock()
+
+	for _, interfaceName := range interfaces {
+		if !active[interfaceName] || monitored[interfaceName] {
+			continue
+		}
+
+		before := len(m.configs)
+		configPath := filepath.Join("/etc/wireguard", interfaceName+".conf")
+		if err := m.parseWireGuardConfig(interfaceName, configPath); err != nil {
+			logger.Warn("Failed to parse config for %s: %v", interfaceName, err)
+			continue
+		}
+		if len(m.configs) > before {
+			added = append(added, interfaceName)
+			logger.Info("Discovered interface %s with %d domain endpoint(s)", interfaceName, len(m.configs)-before)
+		}
+	}
+
+	if len(added) > 0 {
+		if err := m.restoreState(); err != nil {
+			logger.Warn("Failed to restore state of discovered interfaces: %v", err)
+		}
+	}
+	m.cycleMu.Lock()
+	m.publishConfigs()
+	m.cycleMu.Unlock()
+	return added, removed, nil
+}
+
+func (m *DDNSMonitor) parseWireGuardConfig(interfaceName, configPath string) error {
+	configs, err := loadWireGuardEndpoints(interfaceName, configPath, m.endpointSource, m.endpointSelection, m.monitorHostnames, m.resolver.Load())
+	for _, config := range configs {
+		logger.Debug("Found domain endpoint: %s -> %s (interface: %s)", config.Hostname, config.LastIP, interfaceName)
+		if config.StaticIP != nil {
+			logger.Debug("Endpoint %s is kept in line with %s through wg set (interface: %s)", config.Endpoint, config.Hostname, interfaceName)
+		}
+		if config.Family != "" {
+			logger.Debug("Endpoint %s overrides family with %s (interface: %s)", config.Hostname, config.Family, interfaceName)
+		}
+		if config.BackupHostname != "" {
+			logger.Debug("Backup endpoint for %s: %s (interface: %s)", config.Hostname, net.JoinHostPort(config.BackupHostname, config.BackupPort), interfaceName)
+		}
+	}
+	m.cycleMu.Lock()
+	m.configs = append(m.configs, configs...)
+	m.cycleMu.Unlock()
+	if isPartialRead(err) {
+		logger.Warn("%v, monitoring the %d endpoint(s) parsed before the error (interface: %s)", err, len(configs), interfaceName)
+		return nil
+	}
+	return err
+}
+
+// CheckOutcome summarizes a check cycle: whether any endpoint changed,
+// whether applying a change failed and whether a restart was held back by
+// maintenance mode, the startup grace period or --manual-restart-cooldown.
+type CheckOutcome struct {
+	Changed bool
+	Failed  bool
+	Skipped bool
+}
+
+const (
+	exitNoChange      = 0
+	exitFatal         = 1
+	exitChangeApplied = 10
+	exitRestartFailed = 20
+)
+
+// runOnce performs a single check cycle against the endpoints the interfaces
+// are currently running with and returns the process exit code for it.
+func (m *DDNSMonitor) runOnce(ctx context.Context) int {
+	if m.maintenance.Load() {
+		logger.Warn("Maintenance mode is on, skipping the check")
+		return exitNoChange
+	}
+	m.seedRunningEndpoints()
+
+	outcome := m.checkEndpoints(ctx)
+	switch {
+	case ctx.Err() != nil:
+		return exitFatal
+	case outcome.Failed, outcome.Skipped:
+		return exitRestartFailed
+	case outcome.Changed:
+		return exitChangeApplied
+	default:
+		return exitNoChange
+	}
+}
+
+// seedRunningEndpoints replaces the addresses resolved at startup with the
+// ones reported by wg showconf, so that a single check detects endpoints that
+// are already out of date. Peers wg cannot report keep the resolved address.
+func (m *DDNSMonitor) seedRunningEndpoints() {
+	live := make(map[string]map[string]string)
+	for _, config := range m.snapshot() {
+		if _, ok := live[config.Interface]; ok {
+			continue
+		}
+		endpoints, err := readLivePeerEndpoints(config.Interface)
+		if err != nil {
+			logger.Warn("Comparing against resolved addresses for %s: %v", config.Interface, err)
+		}
+		live[config.Interface] = endpoints
+	}
+
+	m.cycleMu.Lock()
+	defer m.cycleMu.Unlock()
+	for i := range m.configs {
+		config := &m.configs[i]
+		host, _, err := net.SplitHostPort(live[config.Interface][config.PublicKey])
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			config.LastIP = normalizeIP(ip)
+		}
+	}
+}
+
+func (m *DDNSMonitor) checkEndpoints(ctx context.Context) CheckOutcome {
+	m.checkMu.Lock()
+	defer m.checkMu.Unlock()
+	m.beginCycle()
+	defer m.commitCycle()
+
+	if m.auditOnly {
+		m.auditEndpoints(ctx)
+		return CheckOutcome{}
+	}
+
+	defer m.flushChangeNotifications()
+
+	var outcome CheckOutcome
+	var restarts []string
+	pending := make(map[string]bool)
+	live := make(map[string]map[string]string)
+	cycleStart := time.Now()
+
+	// Every restart goes through the loop at the end of the cycle, which
+	// applies maintenance mode, the startup grace period and
+	// --manual-restart-cooldown and restarts each interface at most once.
+	queueRestart := func(interfaceName string) {
+		if !pending[interfaceName] {
+			pending[interfaceName] = true
+			restarts = append(restarts, interfaceName)
+		}
+	}
+
+	for i := range m.work {
+		if ctx.Err() != nil {
+			logger.Info("Check cycle aborted: shutting down")
+			return outcome
+		}
+
+		config := &m.work[i]
+
+		if config.Interval == 0 {
+			config.Interval = m.checkInterval
+		}
+		if m.adaptive() && config.NextCheckAt.Sub(cycleStart) > m.minInterval/2 {
+			continue
+		}
+		config.NextCheckAt = cycleStart.Add(config.Interval)
+
+		logger.Debug("Resolving DNS for %s (interface: %s)", config.Hostname, config.Interface)
+		config.LastCheckAt = time.Now()
+		result, err := m.resolve(ctx, config.Hostname, config.Family)
+		if err != nil {
+			if ctx.Err() != nil {
+				logger.Info("Check cycle aborted: shutting down")
+				return outcome
+			}
+			// Transient failures count towards failover and --fail-action
+			// like any other, but a streak ending in one only reaches the
+			// action at --transient-fail-threshold, and they are logged
+			// quietly until then.
+			transient := isTransientLookupError(err)
+			if transient {
+				logger.Info("Transient failure resolving %s, retrying on the next check: %v", config.Hostname, err)
+			} else {
+				logger.Warn("Failed to resolve %s: %v", config.Hostname, err)
+			}
+			if config.Disabled || errors.Is(err, errDNSSECUnauthenticated) {
+				continue
+			}
+			config.ConsecutiveFailures++
+			if config.BackupHostname != "" && config.ConsecutiveFailures >= m.failoverAfter {
+				m.failoverToBackup(ctx, config)
+			}
+			threshold := m.failThreshold
+			if transient {
+				threshold = m.transientFailThreshold
+			}
+			if !config.FailActionTaken && config.ConsecutiveFailures >= threshold {
+				config.FailActionTaken = true
+				if m.handleResolutionFailure(config) {
+					queueRestart(config.Interface)
+				}
+			}
+			continue
+		}
+
+		config.ConsecutiveFailures = 0
+		config.FailActionTaken = false
+		resolvedIP := result.Chosen
+		if m.familyOf(config) == familyDual {
+			logger.Debug("DNS resolution result for %s: ipv4=%s ipv6=%s (interface: %s)", config.Hostname, result.IPv4, result.IPv6, config.Interface)
+		}
+		if len(result.Addresses) > 1 {
+			logger.Debug("Resolved address set for %s (interface: %s): %s", config.Hostname, config.Interface, result.Fingerprint())
+		}
+		config.LastIPv4 = result.IPv4
+		config.LastIPv6 = result.IPv6
+		config.ResolvedIP = resolvedIP
+		config.Addresses = result.Addresses
+		config.FamilyReason = result.Reason
+
+		if config.Disabled {
+			if !config.LastIP.Equal(resolvedIP) {
+				logger.Info("Ignoring IP change for %s: %s -> %s, monitoring disabled (interface: %s)",
+					config.Hostname, config.LastIP, resolvedIP, config.Interface)
+			}
+			continue
+		}
+
+		if config.UsingBackup {
+			logger.Warn("Primary endpoint %s resolves again, failing back from %s (interface: %s)",
+				config.Hostname, config.BackupHostname, config.Interface)
+			config.UsingBackup = false
+			config.BackupIP = nil
+
+			// Restarting would bring back the IP literal from the config file,
+			// so static endpoints fail back through syncStaticEndpoint below.
+			if config.StaticIP == nil {
+				config.LastIP = resolvedIP
+				outcome.Changed = true
+				queueRestart(config.Interface)
+				continue
+			}
+		}
+
+		logger.Debug("DNS resolution result for %s: %s (interface: %s)", config.Hostname, resolvedIP, config.Interface)
+
+		if config.StaticIP != nil {
+			updated, err := m.syncStaticEndpoint(ctx, config, resolvedIP, live)
+			outcome.Changed = outcome.Changed || updated || err != nil
+			outcome.Failed = outcome.Failed || err != nil
+			continue
+		}
+
+		hits := m.observeIP(config, result)
+
+		if m.endpointChanged(config, result) {
+			if hits < m.changeWindowHits {
+				logger.Info("Possible IP change for %s: %s -> %s seen on %d of the last %d checks, waiting for %d (interface: %s)",
+					config.Hostname, config.LastIP, resolvedIP, hits, len(config.RecentIPs), m.changeWindowHits, config.Interface)
+				continue
+			}
+
+			m.recordChange(config, result)
+			outcome.Changed = true
+			queueRestart(config.Interface)
+			m.adaptInterval(config, cycleStart, true)
+		} else {
+			config.Fingerprint = result.Fingerprint()
+			m.adaptInterval(config, cycleStart, false)
+		}
+	}
+
+	if len(restarts) > 0 && m.coalesceWindow > 0 {
+		m.coalesceChanges(ctx, pending, cycleStart)
+	}
+	m.commitCycle()
+
+	// The tracked address of an interface whose restart was deferred by
+	// --manual-restart-cooldown already matches DNS, so it is queued again
+	// here until the restart has happened.
+	for i := range m.work {
+		if m.deferredRestarts[m.work[i].Interface] {
+			queueRestart(m.work[i].Interface)
+		}
+	}
+
+	for _, interfaceName := range restarts {
+		if ctx.Err() != nil {
+			logger.Warn("Skipping restart of %s: shutting down", m.unitName(interfaceName))
+			continue
+		}
+
+		if m.maintenance.Load() {
+			logger.Info("Skipping restart of %s: maintenance mode is on", m.unitName(interfaceName))
+			outcome.Skipped = true
+			continue
+		}
+
+		if remaining, ok := m.inStartupGrace(); ok {
+			logger.Info("Skipping restart of %s: startup grace period has %v left", m.unitName(interfaceName), remaining.Round(time.Second))
+			outcome.Skipped = true
+			continue
+		}
+
+		if since, ok := m.recentExternalRestart(ctx, interfaceName); ok {
+			logger.Info("Deferring restart of %s: restarted outside wg-ddns %v ago, retrying once the %v cooldown has passed",
+				m.unitName(interfaceName), since.Round(time.Second), m.manualCooldown)
+			if m.deferredRestarts == nil {
+				m.deferredRestarts = make(map[string]bool)
+			}
+			m.deferredRestarts[interfaceName] = true
+			outcome.Skipped = true
+			continue
+		}
+		delete(m.deferredRestarts, interfaceName)
+
+		if err := m.restartWireGuardService(ctx, interfaceName); err != nil {
+			logger.Error("Failed to restart %s: %v", m.unitName(interfaceName), err)
+			outcome.Failed = true
+		} else {
+			logger.Warn("Successfully restarted %s", m.unitName(interfaceName))
+		}
+	}
+
+	return outcome
+}
+
+// AuditLog appends the records of --audit-only to a CSV file.
+type AuditLog struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+func openAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file %s: %w", path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open audit file %s: %w", path, err)
+	}
+
+	audit := &AuditLog{file: file, writer: csv.NewWriter(file)}
+	if info.Size() == 0 {
+		if err := audit.Write([]string{"timestamp", "interface", "hostname", "ip", "error"}); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to write audit file %s: %w", path, err)
+		}
+	}
+	return audit, nil
+}
+
+func (a *AuditLog) Write(record []string) error {
+	a.writer.Write(record)
+	a.writer.Flush()
+	return a.writer.Error()
+}
+
+func (a *AuditLog) Close() error {
+	return a.file.Close()
+}
+
+// auditEndpoints is the check cycle of --audit-only. Every endpoint is
+// resolved and recorded, changes are logged and tracked for the API, but no
+// interface is restarted, no peer updated and no notification sent.
+func (m *DDNSMonitor) auditEndpoints(ctx context.Context) {
+	for i := range m.work {
+		if ctx.Err() != nil {
+			return
+		}
+
+		config := &m.work[i]
+		now := time.Now()
+		config.LastCheckAt = now
+		record := []string{now.Format(time.RFC3339), config.Interface, config.Hostname, "", ""}
+
+		result, err := m.resolve(ctx, config.Hostname, config.Family)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			record[4] = err.Error()
+			logger.Warn("Audit: %s failed to resolve: %v (interface: %s)", config.Hostname, err, config.Interface)
+		} else {
+			record[3] = ipString(result.Chosen)
+			logger.Info("Audit: %s resolves to %s (interface: %s)", config.Hostname, record[3], config.Interface)
+
+			if config.LastIP != nil && !config.LastIP.Equal(result.Chosen) {
+				logger.Warn("IP change detected for %s: %s -> %s (interface: %s)",
+					config.Hostname, config.LastIP, result.Chosen, config.Interface)
+				config.ChangeCount++
+				config.LastChangeAt = now
+			}
+			config.LastIP = result.Chosen
+			config.LastIPv4 = result.IPv4
+			config.LastIPv6 = result.IPv6
+			config.ResolvedIP = result.Chosen
+			config.Addresses = result.Addresses
+			config.FamilyReason = result.Reason
+		}
+
+		if m.audit != nil {
+			if err := m.audit.Write(record); err != nil {
+				logger.Error("Failed to write audit record for %s: %v", config.Hostname, err)
+			}
+		}
+	}
+}
+
+// endpointChanged reports whether result differs from what config last acted
+// on: the chosen address, or with --compare-mode set also any other address.
+func (m *DDNSMonitor) endpointChanged(config *Config, result *Resolution) bool {
+	if !config.LastIP.Equal(result.Chosen) {
+		return true
+	}
+	return m.compareMode == compareSet && config.Fingerprint != "" && config.Fingerprint != result.Fingerprint()
+}
+
+// observeIP adds the outcome of a resolution to the --change-window history of
+// config and returns on how many of the remembered checks it was seen.
+func (m *DDNSMonitor) observeIP(config *Config, result *Resolution) int {
+	if m.changeWindowSize == 0 {
+		return 0
+	}
+
+	observed := result.Chosen.String()
+	if m.compareMode == compareSet {
+		observed += " " + result.Fingerprint()
+	}
+
+	config.RecentIPs = append(config.RecentIPs, observed)
+	if len(config.RecentIPs) > m.changeWindowSize {
+		config.RecentIPs = config.RecentIPs[len(config.RecentIPs)-m.changeWindowSize:]
+	}
+
+	hits := 0
+	for _, seen := range config.RecentIPs {
+		if seen == observed {
+			hits++
+		}
+	}
+	return hits
+}
+
+func (m *DDNSMonitor) recordChange(config *Config, result *Resolution) {
+	resolvedIP := result.Chosen
+	if config.LastIP.Equal(resolvedIP) {
+		logger.Warn("Address set change detected for %s: %s -> %s (interface: %s)",
+			config.Hostname, config.Fingerprint, result.Fingerprint(), config.Interface)
+	} else {
+		logger.Warn("IP change detected for %s: %s -> %s (interface: %s)",
+			config.Hostname, config.LastIP, resolvedIP, config.Interface)
+		m.notifyChange(config, config.LastIP, resolvedIP)
+	}
+
+	config.LastIP = resolvedIP
+	config.Fingerprint = result.Fingerprint()
+	config.ChangeCount++
+	config.LastChangeAt = time.Now()
+}
+
+// coalesceChanges waits --coalesce-window before the interfaces in pending are
+// restarted and then resolves their other endpoints again, so that changes
+// arriving shortly after the first one, as during a mass DNS update, are
+// applied by the same restart instead of triggering another one next cycle.
+func (m *DDNSMonitor) coalesceChanges(ctx context.Context, pending map[string]bool, cycleStart time.Time) {
+	logger.Info("Waiting %v to coalesce further changes before restarting", m.coalesceWindow)
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(m.coalesceWindow):
+	}
+
+	for i := range m.work {
+		config := &m.work[i]
+		if !pending[config.Interface] || config.Disabled || config.UsingBackup || config.StaticIP != nil {
+			continue
+		}
+
+		result, err := m.resolve(ctx, config.Hostname, config.Family)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Debug("Failed to resolve %s while coalescing changes: %v", config.Hostname, err)
+			continue
+		}
+		config.LastIPv4 = result.IPv4
+		config.LastIPv6 = result.IPv6
+		config.ResolvedIP = result.Chosen
+		config.Addresses = result.Addresses
+		config.FamilyReason = result.Reason
+		hits := m.observeIP(config, result)
+		if !m.endpointChanged(config, result) || hits < m.changeWindowHits {
+			continue
+		}
+
+		m.recordChange(config, result)
+		m.adaptInterval(config, cycleStart, true)
+	}
+}
+
+// syncStaticEndpoint keeps the running endpoint of a peer configured with an
+// IP literal in line with its monitoring hostname. Restarting the interface
+// would only re-apply the literal, so the peer is updated with wg set whenever
+// the endpoint reported by wg showconf differs from the resolved address.
+func (m *DDNSMonitor) syncStaticEndpoint(ctx context.Context, config *Config, resolvedIP net.IP, live map[string]map[string]string) (bool, error) {
+	if !config.LastIP.Equal(resolvedIP) {
+		logger.Warn("IP change detected for %s: %s -> %s (interface: %s)",
+			config.Hostname, config.LastIP, resolvedIP, config.Interface)
+		m.notifyChange(config, config.LastIP, resolvedIP)
+		config.LastIP = resolvedIP
+		config.ChangeCount++
+		config.LastChangeAt = time.Now()
+	}
+
+	endpoints, ok := live[config.Interface]
+	if !ok {
+		var err error
+		endpoints, err = readLivePeerEndpoints(config.Interface)
+		if err != nil {
+			logger.Warn("Cannot compare running endpoint of %s: %v", config.Hostname, err)
+		}
+		live[config.Interface] = endpoints
+	}
+	if endpoints == nil {
+		return false, nil
+	}
+
+	want := net.JoinHostPort(resolvedIP.String(), config.EndpointPort())
+	if endpoints[config.PublicKey] == want {
+		return false, nil
+	}
+	if config.PublicKey == "" {
+		logger.Error("Cannot update endpoint for %s: peer public key not found (interface: %s)", config.Hostname, config.Interface)
+		return false, fmt.Errorf("peer public key not found")
+	}
+
+	logger.Warn("Running endpoint %s diverges from %s (%s), updating peer (interface: %s)",
+		endpoints[config.PublicKey], config.Hostname, want, config.Interface)
+	if err := setPeerEndpoint(ctx, config.Interface, config.PublicKey, want); err != nil {
+		logger.Error("Failed to update endpoint for %s: %v", config.Hostname, err)
+		return false, err
+	}
+	endpoints[config.PublicKey] = want
+	logger.Warn("Successfully updated endpoint of %s to %s", config.Interface, want)
+	return true, nil
+}
+
+func (m *DDNSMonitor) adaptive() bool {
+	return m.minInterval < m.maxInterval
+}
+
+// adaptInterval drops the check interval of an endpoint that just changed to
+// the minimum, expecting follow-up changes, and doubles it up to the maximum
+// for every check on which it stayed the same.
+func (m *DDNSMonitor) adaptInterval(config *Config, cycleStart time.Time, changed bool) {
+	if !m.adaptive() {
+		return
+	}
+
+	previous := config.Interval
+	if changed {
+		config.Interval = m.minInterval
+	} else {
+		config.Interval = min(config.Interval*2, m.maxInterval)
+	}
+	config.NextCheckAt = cycleStart.Add(config.Interval)
+
+	if config.Interval != previous {
+		logger.Debug("Check interval for %s is now %v (interface: %s)", config.Hostname, config.Interval, config.Interface)
+	}
+}
+
+// handleResolutionFailure applies --fail-action to an endpoint that has
+// reached the failure threshold and reports whether its interface should be
+// restarted. The restart itself is left to the restart loop of the cycle.
+func (m *DDNSMonitor) handleResolutionFailure(config *Config) bool {
+	switch m.failAction {
+	case failActionNotify:
+		logger.Error("%s has failed to resolve for %d consecutive checks (interface: %s)",
+			config.Hostname, config.ConsecutiveFailures, config.Interface)
+	case failActionRestart:
+		if config.UsingBackup {
+			logger.Warn("Not restarting %s: %s is running on its backup endpoint", m.unitName(config.Interface), config.Hostname)
+			return false
+		}
+		logger.Warn("%s has failed to resolve for %d consecutive checks, restarting %s",
+			config.Hostname, config.ConsecutiveFailures, m.unitName(config.Interface))
+		return true
+	}
+	return false
+}
+
+func (m *DDNSMonitor) failoverToBackup(ctx context.Context, config *Config) {
+	if config.PublicKey == "" {
+		logger.Error("Cannot fail over %s to backup %s: peer public key not found (interface: %s)",
+			config.Hostname, config.BackupHostname, config.Interface)
+		return
+	}
+
+	result, err := m.resolve(ctx, config.BackupHostname, config.Family)
+	if err != nil {
+		logger.Warn("Failed to resolve backup %s: %v", config.BackupHostname, err)
+		return
+	}
+	ip := result.Chosen
+
+	if config.UsingBackup && config.BackupIP.Equal(ip) {
+		return
+	}
+
+	endpoint := net.JoinHostPort(ip.String(), config.BackupPort)
+	logger.Warn("Primary endpoint %s failed %d consecutive checks, switching to backup %s (%s) (interface: %s)",
+		config.Hostname, config.ConsecutiveFailures, config.BackupHostname, endpoint, config.Interface)
+
+	if err := setPeerEndpoint(ctx, config.Interface, config.PublicKey, endpoint); err != nil {
+		logger.Error("Failed to switch %s to backup endpoint: %v", config.Interface, err)
+		return
+	}
+
+	config.UsingBackup = true
+	config.BackupIP = ip
+	logger.Warn("Successfully switched %s to backup endpoint %s", config.Interface, endpoint)
+}
+
+func setPeerEndpoint(ctx context.Context, interfaceName, publicKey, endpoint string) error {
+	if err := validateInterfaceName(interfaceName); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "wg", "set", interfaceName, "peer", publicKey, "endpoint", endpoint).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("wg set failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+const notifyExecTimeout = 30 * time.Second
+
+// ChangeEvent holds the variables available to the --notify-exec template.
+type ChangeEvent struct {
+	Interface string            `json:"interface"`
+	Hostname  string            `json:"hostname"`
+	Endpoint  string            `json:"endpoint"`
+	OldIP     string            `json:"old_ip"`
+	NewIP     string            `json:"new_ip"`
+	Time      string            `json:"time"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// ChangeBatch holds the variables available to the --notify-exec template
+// with --notify-batch. The changes are also written to the command's stdin
+// as a JSON array.
+type ChangeBatch struct {
+	Count   int
+	Time    string
+	Changes []ChangeEvent
+}
+
+// parseNotifyCommand splits command into words like a shell would and parses
+// each word as a template. The words are rendered one by one and the program
+// is started without a shell, so event values always end up as (part of) a
+// single argument and cannot inject further arguments or shell syntax. sample
+// is the value the templates will be rendered with, a ChangeEvent or a
+// ChangeBatch, used to reject unknown fields up front.
+func parseNotifyCommand(command string, sample any) ([]*template.Template, error) {
+	words, err := splitCommandLine(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("command is empty")
+	}
+
+	templates := make([]*template.Template, 0, len(words))
+	for i, word := range words {
+		tmpl, err := template.New(fmt.Sprintf("argument %d", i)).Option("missingkey=error").Parse(word)
+		if err != nil {
+			return nil, err
+		}
+		if err := tmpl.Execute(io.Discard, sample); err != nil {
+			return nil, err
+		}
+		templates = append(templates, tmpl)
+	}
+	return templates, nil
+}
+
+// splitCommandLine splits command on unquoted whitespace, honouring single
+// quotes, double quotes and backslash escapes. Template actions ({{ ... }})
+// are copied verbatim so they may contain spaces and quotes of their own.
+func splitCommandLine(command string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var quote byte
+	inWord, escaped := false, false
+
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case escaped:
+			if quote == '"' && c != '"' && c != '\\' {
+				word.WriteByte('\\')
+			}
+			word.WriteByte(c)
+			escaped = false
+		case strings.HasPrefix(command[i:], "{{"):
+			end := strings.Index(command[i:], "}}")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated template action at offset %d", i)
+			}
+			word.WriteString(command[i : i+end+2])
+			inWord = true
+			i += end + 1
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\\':
+			escaped = true
+			inWord = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// notifyChange runs the --notify-exec command for an IP change in the
+// background, or queues the change for the end of the check cycle with
+// --notify-batch. cleanup waits for commands still running before exiting.
+func (m *DDNSMonitor) notifyChange(config *Config, oldIP, newIP net.IP) {
+	if m.notifyCommand == nil {
+		return
+	}
+
+	event := ChangeEvent{
+		Interface: config.Interface,
+		Hostname:  config.Hostname,
+		Endpoint:  config.Endpoint,
+		OldIP:     ipString(oldIP),
+		NewIP:     ipString(newIP),
+		Time:      time.Now().Format(time.RFC3339),
+		Labels:    config.Labels,
+	}
+
+	if m.notifyBatch {
+		m.pendingChanges = append(m.pendingChanges, event)
+		return
+	}
+	m.runNotifyCommand(event, nil, event.Hostname)
+}
+
+// flushChangeNotifications runs the --notify-exec command once for the
+// changes queued during a check cycle with --notify-batch.
+func (m *DDNSMonitor) flushChangeNotifications() {
+	if len(m.pendingChanges) == 0 {
+		return
+	}
+
+	batch := ChangeBatch{
+		Count:   len(m.pendingChanges),
+		Time:    time.Now().Format(time.RFC3339),
+		Changes: m.pendingChanges,
+	}
+	m.pendingChanges = nil
+
+	stdin, err := json.Marshal(batch.Changes)
+	if err != nil {
+		logger.Error("Failed to encode %d change(s) for the notify command: %v", batch.Count, err)
+		return
+	}
+	m.runNotifyCommand(batch, stdin, fmt.Sprintf("%d change(s)", batch.Count))
+}
+
+// runNotifyCommand renders the --notify-exec command with data and runs it in
+// the background, feeding it stdin if not nil. subject names what the command
+// is run for in the logs.
+func (m *DDNSMonitor) runNotifyCommand(data any, stdin []byte, subject string) {
+	argv := make([]string, 0, len(m.notifyCommand))
+	for _, tmpl := range m.notifyCommand {
+		var arg strings.Builder
+		if err := tmpl.Execute(&arg, data); err != nil {
+			logger.Error("Failed to render notify command for %s: %v", subject, err)
+			return
+		}
+		argv = append(argv, arg.String())
+	}
+
+	m.notifyWG.Add(1)
+	go func() {
+		defer m.notifyWG.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), notifyExecTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+		if stdin != nil {
+			cmd.Stdin = bytes.NewReader(stdin)
+		}
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			logger.Warn("Notify command for %s failed: %v: %s", subject, err, strings.TrimSpace(string(output)))
+			return
+		}
+		logger.Debug("Notify command for %s completed", subject)
+	}()
+}
+
+func (m *DDNSMonitor) restartWireGuardService(ctx context.Context, interfaceName string) error {
+	if err := validateInterfaceName(interfaceName); err != nil {
+		return err
+	}
+
+	if !m.beginRestart(interfaceName) {
+		return errRestartInProgress
+	}
+
+	// The restart outlives ctx: a client that disconnects must not release
+	// the interface while its systemd job is still running, so the lock is
+	// only dropped once the job result arrived or restartTimeout passed.
+	done := make(chan error, 1)
+	go func() {
+		defer m.endRestart(interfaceName)
+		done <- m.performRestart(context.WithoutCancel(ctx), interfaceName)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("stopped waiting for %s to restart: %w", m.unitName(interfaceName), ctx.Err())
+	}
+}
+
+// performRestart restarts the unit of interfaceName while the caller holds
+// its restart lock.
+func (m *DDNSMonitor) performRestart(ctx context.Context, interfaceName string) error {
+	serviceName := m.unitName(interfaceName)
+
+	if err := m.checkUnitLoadState(ctx, interfaceName); err != nil {
+		return err
+	}
+
+	reserved, err := m.reserveRestart()
+	if err != nil {
+		return err
+	}
+
+	conn := m.systemd()
+	err = m.runRestartJob(ctx, conn, serviceName)
+	if err != nil && !conn.Connected() {
+		logger.Warn("Lost the systemd connection while restarting %s: %v", serviceName, err)
+		if reconnectErr := m.reconnectSystemd(ctx, conn); reconnectErr != nil {
+			return fmt.Errorf("%w, %v", err, reconnectErr)
+		}
+		logger.Info("Retrying restart of %s", serviceName)
+		err = m.runRestartJob(ctx, m.systemd(), serviceName)
+	}
+	if err != nil {
+		m.releaseRestart(reserved)
+		return err
+	}
+
+	m.restartMu.Lock()
+	if m.lastRestart == nil {
+		m.lastRestart = make(map[string]time.Time)
+	}
+	m.lastRestart[interfaceName] = time.Now()
+	m.restartMu.Unlock()
+
+	m.applyEndpointOverrides(ctx, interfaceName)
+	return nil
+}
+
+// runRestartJob queues the restart job of serviceName according to the
+// restart mode and waits for it to finish. A dropped connection never
+// delivers the job result, so it is polled while waiting.
+func (m *DDNSMonitor) runRestartJob(ctx context.Context, conn *dbus.Conn, serviceName string) error {
+	reschan := make(chan string, 1)
+	var err error
+	switch m.restartMode {
+	case restartModeReload:
+		_, err = conn.ReloadUnitContext(ctx, serviceName, "replace", reschan)
+		if err != nil && conn.Connected() {
+			logger.Warn("Reload of %s not possible (%v), falling back to restart", serviceName, err)
+			_, err = conn.RestartUnitContext(ctx, serviceName, "replace", reschan)
+		}
+	case restartModeReloadOrRestart:
+		_, err = conn.ReloadOrRestartUnitContext(ctx, serviceName, "replace", reschan)
+	default:
+		_, err = conn.RestartUnitContext(ctx, serviceName, "replace", reschan)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restart service %s: %w", serviceName, err)
+	}
+
+	timeout := time.NewTimer(restartTimeout)
+	defer timeout.Stop()
+	poll := time.NewTicker(time.Second)
+	defer poll.Stop()
+
+	for {
+		select {
+		case job := <-reschan:
+			if job != "done" {
+				return fmt.Errorf("service restart job failed: %s", job)
+			}
+			return nil
+		case <-poll.C:
+			if !conn.Connected() {
+				return fmt.Errorf("lost the systemd connection waiting for %s to restart", serviceName)
+			}
+		case <-timeout.C:
+			return fmt.Errorf("timed out waiting for %s to restart", serviceName)
+		case <-ctx.Done():
+			return fmt.Errorf("stopped waiting for %s to restart: %w", serviceName, ctx.Err())
+		}
+	}
+}
+
+// systemd returns the current systemd connection, which reconnectSystemd
+// may replace.
+func (m *DDNSMonitor) systemd() *dbus.Conn {
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+	return m.conn
+}
+
+// reconnectSystemd replaces the dropped connection old, retrying with
+// exponential backoff, so that a restart of systemd or of the bus does not
+// leave the daemon unable to restart interfaces. Nothing is done when another
+// caller already replaced old.
+func (m *DDNSMonitor) reconnectSystemd(ctx context.Context, old *dbus.Conn) error {
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+
+	if m.conn != old {
+		return nil
+	}
+
+	backoff := reconnectBackoff
+	var err error
+	for attempt := 1; attempt <= reconnectAttempts; attempt++ {
+		logger.Warn("Reconnecting to systemd (attempt %d/%d)", attempt, reconnectAttempts)
+		var conn *dbus.Conn
+		var bus string
+		conn, bus, err = connectSystemd(ctx, m.systemdBus)
+		if err == nil {
+			old.Close()
+			m.conn = conn
+			logger.Info("Reconnected to systemd over the %s bus", bus)
+			return nil
+		}
+		logger.Warn("Failed to reconnect to systemd: %v", err)
+
+		if attempt == reconnectAttempts {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+	return fmt.Errorf("failed to reconnect to systemd after %d attempts: %w", reconnectAttempts, err)
+}
+
+// applyEndpointOverrides corrects the peers of an interface after a restart
+// brought them up with wg-quick's own resolution and the port from the
+// configuration file: peers with a port override are moved to that port and
+// peers whose address was chosen through --prefer-cidr to that address.
+func (m *DDNSMonitor) applyEndpointOverrides(ctx context.Context, interfaceName string) {
+	resolver := m.resolver.Load()
+	var endpoints map[string]string
+	configs := m.snapshot()
+	for i := range configs {
+		config := &configs[i]
+		if config.Interface != interfaceName || config.UsingBackup || config.StaticIP != nil {
+			continue
+		}
+		preferred := resolver.preferredNetwork(config.LastIP) != nil
+		if config.PortOverride == "" && !preferred {
+			continue
+		}
+		if config.PublicKey == "" {
+			logger.Error("Cannot apply endpoint override to %s: peer public key not found (interface: %s)", config.Hostname, interfaceName)
+			continue
+		}
+		if endpoints == nil {
+			var err error
+			endpoints, err = readLivePeerEndpoints(interfaceName)
+			if err != nil {
+				logger.Error("Cannot apply endpoint overrides on %s: %v", interfaceName, err)
+				return
+			}
+		}
+
+		running := endpoints[config.PublicKey]
+		host, _, err := net.SplitHostPort(running)
+		if err != nil {
+			logger.Warn("Cannot apply endpoint override to %s: no running endpoint (interface: %s)", config.Hostname, interfaceName)
+			continue
+		}
+		if preferred {
+			host = normalizeIP(config.LastIP).String()
+		}
+		want := net.JoinHostPort(host, config.EndpointPort())
+		if want == running {
+			continue
+		}
+		if err := setPeerEndpoint(ctx, interfaceName, config.PublicKey, want); err != nil {
+			logger.Error("Failed to apply endpoint override to %s: %v", config.Hostname, err)
+			continue
+		}
+		logger.Info("Applied endpoint override to %s, endpoint is now %s (interface: %s)", config.Hostname, want, interfaceName)
+	}
+}
+
+// restartDeferral tells why the restart loop of a check would hold back a
+// restart of interfaceName now, or returns "" if it would restart it.
+func (m *DDNSMonitor) restartDeferral(ctx context.Context, interfaceName string) string {
+	if m.maintenance.Load() {
+		return "maintenance mode is on"
+	}
+	if remaining, ok := m.inStartupGrace(); ok {
+		return fmt.Sprintf("startup grace period has %v left", remaining.Round(time.Second))
+	}
+	if since, ok := m.recentExternalRestart(ctx, interfaceName); ok {
+		return fmt.Sprintf("restarted outside wg-ddns %v ago", since.Round(time.Second))
+	}
+	return ""
+}
+
+func (m *DDNSMonitor) inStartupGrace() (time.Duration, bool) {
+	if m.startupGrace <= 0 {
+		return 0, false
+	}
+	remaining := m.startupGrace - time.Since(m.startedAt)
+	return remaining, remaining > 0
+}
+
+// recentExternalRestart reports how long ago an interface was restarted
+// outside wg-ddns, if that is within --manual-restart-cooldown. It uses the
+// activation time of the unit rather than the latest handshakes of its peers,
+// which WireGuard renews every two minutes on any active tunnel and so do not
+// tell a manual restart apart from normal traffic.
+func (m *DDNSMonitor) recentExternalRestart(ctx context.Context, interfaceName string) (time.Duration, bool) {
+	if m.manualCooldown <= 0 {
+		return 0, false
+	}
+
+	serviceName := m.unitName(interfaceName)
+	property, err := m.systemd().GetUnitPropertyContext(ctx, serviceName, "ActiveEnterTimestamp")
+	if err != nil {
+		logger.Debug("Failed to read ActiveEnterTimestamp of %s: %v", serviceName, err)
+		return 0, false
+	}
+
+	usec, ok := property.Value.Value().(uint64)
+	if !ok || usec == 0 {
+		return 0, false
+	}
+	activeSince := time.UnixMicro(int64(usec))
+
+	m.restartMu.Lock()
+	ownRestart := m.lastRestart[interfaceName]
+	m.restartMu.Unlock()
+
+	if !activeSince.After(ownRestart) {
+		return 0, false
+	}
+
+	since := time.Since(activeSince)
+	return since, since < m.manualCooldown
+}
+
+func (m *DDNSMonitor) checkUnitLoadState(ctx context.Context, interfaceName string) error {
+	serviceName := m.unitName(interfaceName)
+	property, err := m.systemd().GetUnitPropertyContext(ctx, serviceName, "LoadState")
+	if err != nil {
+		logger.Debug("Failed to read LoadState of %s: %v", serviceName, err)
+		return nil
+	}
+
+	loadState, _ := property.Value.Value().(string)
+	switch loadState {
+	case "masked":
+		return fmt.Errorf("unit %s is masked, unmask it with 'systemctl unmask %s'", serviceName, serviceName)
+	case "not-found":
+		return fmt.Errorf("unit %s not found, check that it is installed and /etc/wireguard/%s.conf exists",
+			serviceName, interfaceName)
+	case "error", "bad-setting":
+		return fmt.Errorf("unit %s failed to load (%s), inspect it with 'systemctl status %s'", serviceName, loadState, serviceName)
+	}
+
+	return nil
+}
+
+func (m *DDNSMonitor) beginRestart(interfaceName string) bool {
+	m.restartMu.Lock()
+	defer m.restartMu.Unlock()
+
+	if m.restarting[interfaceName] {
+		return false
+	}
+	if m.restarting == nil {
+		m.restarting = make(map[string]bool)
+	}
+	m.restarting[interfaceName] = true
+	return true
+}
+
+func (m *DDNSMonitor) endRestart(interfaceName string) {
+	m.restartMu.Lock()
+	defer m.restartMu.Unlock()
+
+	delete(m.restarting, interfaceName)
+}
+
+// reserveRestart counts a restart against --max-restarts-per-hour over a
+// sliding one-hour window and refuses it once the limit has been reached, so
+// that a misbehaving resolver cannot keep every interface bouncing. It
+// returns the time of the reservation for releaseRestart.
+func (m *DDNSMonitor) reserveRestart() (time.Time, error) {
+	m.restartMu.Lock()
+	defer m.restartMu.Unlock()
+
+	now := time.Now()
+	m.pruneRestartTimes(now)
+	if m.maxRestartsHour > 0 && len(m.restartTimes) >= m.maxRestartsHour {
+		resetsIn := m.restartTimes[0].Add(restartLimitWindow).Sub(now)
+		return time.Time{}, fmt.Errorf("%w: %d restarts within the last hour, the next one is allowed in %v",
+			errRestartLimitReached, len(m.restartTimes), resetsIn.Round(time.Second))
+	}
+	m.restartTimes = append(m.restartTimes, now)
+	return now, nil
+}
+
+// releaseRestart gives back a reservation of reserveRestart whose restart
+// failed, so that a broken unit does not use up the budget of the others.
+func (m *DDNSMonitor) releaseRestart(reserved time.Time) {
+	m.restartMu.Lock()
+	defer m.restartMu.Unlock()
+
+	if i := slices.Index(m.restartTimes, reserved); i >= 0 {
+		m.restartTimes = slices.Delete(m.restartTimes, i, i+1)
+	}
+}
+
+func (m *DDNSMonitor) pruneRestartTimes(now time.Time) {
+	keep := 0
+	for keep < len(m.restartTimes) && now.Sub(m.restartTimes[keep]) >= restartLimitWindow {
+		keep++
+	}
+	m.restartTimes = m.restartTimes[keep:]
+}
+
+func (m *DDNSMonitor) restartBreakerStatus() RestartBreakerStatus {
+	m.restartMu.Lock()
+	defer m.restartMu.Unlock()
+
+	now := time.Now()
+	m.pruneRestartTimes(now)
+	status := RestartBreakerStatus{
+		MaxPerHour:       m.maxRestartsHour,
+		RestartsLastHour: len(m.restartTimes),
+	}
+	if m.maxRestartsHour > 0 && len(m.restartTimes) >= m.maxRestartsHour {
+		status.Open = true
+		status.ResetsAt = m.restartTimes[0].Add(restartLimitWindow).Format(time.RFC3339)
+	}
+	return status
+}
+
+func (m *DDNSMonitor) startHTTPServer(ctx context.Context) {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.HandleMethodNotAllowed = true
+	router.Use(gin.Recovery())
+	router.Use(m.loggingMiddleware())
+	router.NoRoute(handleNoRoute)
+	router.NoMethod(handleNoMethod)
+
+	router.GET("/healthz", m.handleHealthz)
+	router.GET("/readyz", m.handleReadyz)
+
+	v1 := router.Group("/api/v1")
+	if !m.disableGzip {
+		v1.Use(gzipMiddleware())
+	}
+	v1.Use(m.authMiddleware())
+	{
+		v1.POST("/restart", m.maintenanceMiddleware(), m.bodyLimitMiddleware(), m.handleRestart)
+		v1.POST("/restart-all", m.maintenanceMiddleware(), m.handleRestartAll)
+		v1.GET("/interfaces", m.handleListInterfaces)
+		v1.GET("/discovered", m.handleListDiscovered)
+		v1.POST("/discover", m.maintenanceMiddleware(), m.handleDiscover)
+		v1.GET("/logs", m.handleLogs)
+		v1.GET("/metrics", m.handleMetrics)
+		v1.POST("/interfaces/:name/disable", m.maintenanceMiddleware(), m.handleDisableInterface)
+		v1.POST("/interfaces/:name/enable", m.maintenanceMiddleware(), m.handleEnableInterface)
+		v1.POST("/interfaces/:name/port", m.maintenanceMiddleware(), m.bodyLimitMiddleware(), m.handleSetPortOverride)
+		v1.POST("/stats/reset", m.maintenanceMiddleware(), m.handleResetStats)
+		v1.GET("/status", m.handleStatus)
+		v1.GET("/preview", m.handlePreview)
+		v1.POST("/dns-cache/flush", m.maintenanceMiddleware(), m.handleFlushDNSCache)
+		v1.POST("/maintenance", m.bodyLimitMiddleware(), m.handleMaintenance)
+	}
+
+	if !m.disableSwagger {
+		router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	}
+
+	if m.dashboard {
+		router.GET("/", m.handleDashboard)
+	}
+
+	addr := fmt.Sprintf("%s:%s", m.listenAddress, m.listenPort)
+	m.httpServer = &http.Server{
+		Addr:    addr,
+		Handler: router,
+	}
+
+	logger.Info("HTTP API server started on %s", addr)
+	if !m.disableSwagger {
+		logger.Info("Swagger UI available at http://%s/swagger/index.html", addr)
+	}
+	if m.dashboard {
+		logger.Info("Dashboard available at http://%s/", addr)
+	}
+
+	go func() {
+		if err := m.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("HTTP server error: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+}
+
+// handleNoRoute answers unknown paths with a JSON error instead of gin's
+// plain-text 404.
+func handleNoRoute(c *gin.Context) {
+	respondError(c, http.StatusNotFound, errorCodeNotFound, fmt.Sprintf("No route for %s %s", c.Request.Method, c.Request.URL.Path))
+}
+
+// handleNoMethod answers known paths requested with an unsupported method.
+func handleNoMethod(c *gin.Context) {
+	respondError(c, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, fmt.Sprintf("Method %s not allowed for %s", c.Request.Method, c.Request.URL.Path))
+}
+
+// handleHealthz reports liveness: the process is up and serving requests.
+// Maintenance mode is reported but does not make the process unhealthy.
+func (m *DDNSMonitor) handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "maintenance": m.maintenance.Load()})
+}
+
+// handleReadyz reports readiness: discovery has completed, every monitored
+// endpoint has been resolved at least once and maintenance mode is off.
+func (m *DDNSMonitor) handleReadyz(c *gin.Context) {
+	if m.maintenance.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "maintenance"})
+		return
+	}
+	if m.ready.Load() {
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+		return
+	}
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"status":     "not ready",
+		"unresolved": m.unresolvedEndpoints(),
+	})
+}
+
+// handleDashboard serves the dashboard page. It is deliberately public: a
+// browser cannot send the X-API-Key header when loading a page, and the page
+// holds no data. Everything it shows or does goes through the authenticated
+// API with the key entered on the page.
+func (m *DDNSMonitor) handleDashboard(c *gin.Context) {
+	page, err := dashboardFS.ReadFile("dashboard/index.html")
+	if err != nil {
+		c.String(http.StatusInternalServerError, "dashboard unavailable")
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page)
+}
+
+func (m *DDNSMonitor) loggingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		duration := time.Since(start)
+		clientIP := c.ClientIP()
+		method := c.Request.Method
+		path := c.Request.URL.Path
+		statusCode := c.Writer.Status()
+
+		// Probes are polled every few seconds and would drown out the rest.
+		if path == "/healthz" || path == "/readyz" {
+			logger.Debug("API %s %s - %d - %v - %s", method, path, statusCode, duration, clientIP)
+			return
+		}
+		logger.Info("API %s %s - %d - %v - %s", method, path, statusCode, duration, clientIP)
+	}
+}
+
+// maintenanceMiddleware rejects requests to endpoints that change anything
+// while maintenance mode is on.
+func (m *DDNSMonitor) maintenanceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if m.maintenance.Load() {
+			logger.Warn("API request %s %s from %s denied - maintenance mode", c.Request.Method, c.Request.URL.Path, c.ClientIP())
+			respondError(c, http.StatusServiceUnavailable, errorCodeMaintenance, "Maintenance mode is on, turn it off with POST /api/v1/maintenance")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func (m *DDNSMonitor) bodyLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, m.maxBodySize)
+		c.Next()
+	}
+}
+
+// gzipMinSize is the smallest response body gzipMiddleware compresses, below
+// it the gzip header and the CPU time outweigh the savings.
+const gzipMinSize = 1024
+
+type bufferedResponseWriter struct {
+	gin.ResponseWriter
+	body    bytes.Buffer
+	written bool
+}
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	w.written = true
+	return w.body.Write(data)
+}
+
+func (w *bufferedResponseWriter) WriteString(s string) (int, error) {
+	w.written = true
+	return w.body.WriteString(s)
+}
+
+// WriteHeaderNow only records that a response was written. The headers are
+// sent once gzipMiddleware knows whether the body is compressed, sending them
+// earlier would leave out Content-Encoding.
+func (w *bufferedResponseWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+// Flush is a no-op for the same reason, the body is only sent at the end.
+func (w *bufferedResponseWriter) Flush() {}
+
+func (w *bufferedResponseWriter) Written() bool {
+	return w.written
+}
+
+func (w *bufferedResponseWriter) Size() int {
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+// gzipMiddleware compresses API responses of at least gzipMinSize bytes for
+// clients that send Accept-Encoding: gzip. Responses are buffered, which is
+// fine for the JSON bodies of the API but not for streaming.
+func gzipMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Vary", "Accept-Encoding")
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		original := c.Writer
+		writer := &bufferedResponseWriter{ResponseWriter: original}
+		c.Writer = writer
+		c.Next()
+		c.Writer = original
+
+		if !bodyAllowed(c.Request.Method, original.Status()) {
+			original.WriteHeaderNow()
+			return
+		}
+		if writer.body.Len() < gzipMinSize {
+			original.WriteHeaderNow()
+			original.Write(writer.body.Bytes())
+			return
+		}
+
+		original.Header().Set("Content-Encoding", "gzip")
+		original.Header().Del("Content-Length")
+		gz := gzip.NewWriter(original)
+		if _, err := gz.Write(writer.body.Bytes()); err != nil {
+			logger.Debug("Failed to write gzip response: %v", err)
+		}
+		gz.Close()
+	}
+}
+
+// bodyAllowed reports whether a response to method with status carries a
+// body, and with it a Content-Encoding.
+func bodyAllowed(method string, status int) bool {
+	if method == http.MethodHead {
+		return false
 	}
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
 
-	if args.version {
-		printVersion()
-		os.Exit(0)
+func acceptsGzip(header string) bool {
+	for _, coding := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		return !found || strings.Trim(q, "0.") != ""
 	}
+	return false
+}
 
-	if args.checkOnly {
-		performCheckOnly(args.singleInterface)
-		os.Exit(0)
+func (m *DDNSMonitor) authMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey := c.GetHeader("X-API-Key")
+		if apiKey == "" {
+			apiKey = bearerToken(c.GetHeader("Authorization"))
+		}
+		if apiKey == m.apiKey {
+			c.Next()
+			return
+		}
+		if scope, ok := m.apiKeyScopes[apiKey]; ok && apiKey != "" {
+			c.Set(apiKeyScopeKey, scope)
+			c.Next()
+			return
+		}
+		logger.Warn("API authentication failed from %s", c.ClientIP())
+		respondError(c, http.StatusUnauthorized, errorCodeUnauthorized, "Invalid API key")
+		c.Abort()
 	}
+}
 
-	logLevel := INFO
-	if args.logLevel != "" {
-		logLevel = parseLogLevel(args.logLevel)
+// apiKeyScopeKey is the gin context key under which authMiddleware stores the
+// interfaces a key from --api-keys-file may act on.
+const apiKeyScopeKey = "apiKeyScope"
+
+// allowedConfigs returns the published configs of the interfaces the key of
+// the request may act on.
+func (m *DDNSMonitor) allowedConfigs(c *gin.Context) []Config {
+	configs := m.snapshot()
+	if _, scoped := c.Get(apiKeyScopeKey); !scoped {
+		return configs
+	}
+	allowed := make([]Config, 0, len(configs))
+	for _, config := range configs {
+		if allowsInterface(c, config.Interface) {
+			allowed = append(allowed, config)
+		}
 	}
+	return allowed
+}
 
-	logger = &Logger{level: logLevel}
+// allowsInterface reports whether the key of the request may act on an
+// interface. The --api-key key is not scoped and may act on all of them.
+func allowsInterface(c *gin.Context, interfaceName string) bool {
+	scope, ok := c.Get(apiKeyScopeKey)
+	if !ok {
+		return true
+	}
+	return scope.(*InterfaceFilter).Match(interfaceName)
+}
 
-	log.SetOutput(io.Discard)
-	gin.DefaultWriter = io.Discard
-	gin.DefaultErrorWriter = io.Discard
+// readAPIKeysFile reads the keys of --api-keys-file. Each line holds a key
+// followed by the interfaces it may act on, in the syntax of --interfaces.
+func readAPIKeysFile(path string) (map[string]*InterfaceFilter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys file %s: %w", path, err)
+	}
 
-	checkInterval := 10 * time.Second
-	if args.checkInterval != "" {
-		var err error
-		checkInterval, err = time.ParseDuration(args.checkInterval)
+	scopes := make(map[string]*InterfaceFilter)
+	for i, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid API keys file %s, line %d: expected a key and a list of interfaces", path, i+1)
+		}
+		if _, ok := scopes[fields[0]]; ok {
+			return nil, fmt.Errorf("invalid API keys file %s, line %d: duplicate key", path, i+1)
+		}
+		filter, err := parseInterfaceFilter(fields[1])
 		if err != nil {
-			logger.Error("Invalid check interval format: %v", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("invalid API keys file %s, line %d: %w", path, i+1, err)
 		}
-		if checkInterval < time.Second {
-			logger.Error("Check interval must be at least 1 second")
-			os.Exit(1)
+		scopes[fields[0]] = filter
+	}
+
+	return scopes, nil
+}
+
+const (
+	minAPIKeyLength  = 16
+	minAPIKeyEntropy = 48.0
+)
+
+func apiKeyWeakness(key string) string {
+	if len(key) < minAPIKeyLength {
+		return fmt.Sprintf("shorter than %d characters", minAPIKeyLength)
+	}
+
+	counts := make(map[rune]int)
+	for _, r := range key {
+		counts[r]++
+	}
+
+	total := float64(len([]rune(key)))
+	var perChar float64
+	for _, count := range counts {
+		p := float64(count) / total
+		perChar -= p * math.Log2(p)
+	}
+
+	if entropy := perChar * total; entropy < minAPIKeyEntropy {
+		return fmt.Sprintf("estimated entropy %.0f bits is below %.0f bits", entropy, minAPIKeyEntropy)
+	}
+	return ""
+}
+
+func bearerToken(header string) string {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// @Summary Restart WireGuard interface
+// @Description Restart a specific WireGuard interface
+// @Tags interfaces
+// @Accept json
+// @Produce json
+// @Param X-API-Key header string true "API Key"
+// @Param request body RestartRequest true "Interface to restart"
+// @Success 200 {object} RestartResponse
+// @Failure 400 {object} ErrorResponse "code is empty_body, malformed_json, invalid_field, missing_field, invalid_interface or single_interface_mode"
+// @Failure 401 {object} ErrorResponse "code is unauthorized"
+// @Failure 403 {object} ErrorResponse "code is audit_only or interface_forbidden"
+// @Failure 404 {object} ErrorResponse "code is interface_not_found"
+// @Failure 409 {object} ErrorResponse "code is restart_in_progress"
+// @Failure 413 {object} ErrorResponse "code is body_too_large"
+// @Failure 429 {object} ErrorResponse "code is restart_limit"
+// @Failure 500 {object} ErrorResponse "code is restart_failed, details.duration_ms is the time spent"
+// @Failure 503 {object} ErrorResponse "code is maintenance"
+// @Router /restart [post]
+func (m *DDNSMonitor) handleRestart(c *gin.Context) {
+	var req RestartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			logger.Warn("API restart request - body exceeds %d bytes from %s", maxBytesErr.Limit, c.ClientIP())
+			respondError(c, http.StatusRequestEntityTooLarge, errorCodeBodyTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+			return
 		}
+
+		code, message := describeBindingError(err, &req)
+		logger.Debug("API restart request - %s from %s: %v", code, c.ClientIP(), err)
+		respondError(c, http.StatusBadRequest, code, message)
+		return
 	}
 
-	apiEnabled := args.listenAddress != "" && args.listenPort != "" && args.apiKey != ""
+	if err := checkInterfaceName(m.namePattern, req.Interface); err != nil {
+		respondInvalidInterface(c, err)
+		return
+	}
 
-	monitor := &DDNSMonitor{
-		singleInterface: args.singleInterface,
-		apiEnabled:      apiEnabled,
-		listenAddress:   args.listenAddress,
-		listenPort:      args.listenPort,
-		apiKey:          args.apiKey,
-		checkInterval:   checkInterval,
+	logger.Info("API restart request for interface '%s' from %s", req.Interface, c.ClientIP())
+
+	if m.auditOnly {
+		logger.Warn("API restart request denied - audit-only mode")
+		respondError(c, http.StatusForbidden, errorCodeAuditOnly, "Restarts are disabled in audit-only mode")
+		return
 	}
 
-	if err := monitor.initialize(); err != nil {
-		logger.Error("Failed to initialize monitor: %v", err)
-		os.Exit(1)
+	if !allowsInterface(c, req.Interface) {
+		logger.Warn("API restart request denied - API key not allowed to restart interface '%s'", req.Interface)
+		respondError(c, http.StatusForbidden, errorCodeInterfaceForbidden, fmt.Sprintf("API key is not allowed to act on interface '%s'", req.Interface))
+		return
 	}
-	defer monitor.cleanup()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	if m.singleInterface != "" && req.Interface != m.singleInterface {
+		logger.Warn("API restart request denied - interface '%s' not allowed (single-interface mode: %s)", req.Interface, m.singleInterface)
+		respondError(c, http.StatusBadRequest, errorCodeSingleInterface, fmt.Sprintf("Only interface '%s' is monitored", m.singleInterface))
+		return
+	}
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	found := false
+	for _, config := range m.snapshot() {
+		if config.Interface == req.Interface {
+			found = true
+			break
+		}
+	}
 
-	go func() {
-		<-sigChan
-		logger.Info("Received shutdown signal")
-		cancel()
-	}()
+	if !found {
+		logger.Warn("API restart request denied - interface '%s' not found in monitored interfaces", req.Interface)
+		respondError(c, http.StatusNotFound, errorCodeInterfaceNotFound, fmt.Sprintf("Interface '%s' not found in monitored interfaces", req.Interface))
+		return
+	}
 
-	if monitor.apiEnabled {
-		go monitor.startHTTPServer(ctx)
+	start := time.Now()
+	err := m.restartWireGuardService(c.Request.Context(), req.Interface)
+	durationMs := time.Since(start).Milliseconds()
+	if err != nil {
+		if errors.Is(err, errRestartInProgress) {
+			logger.Warn("API restart request rejected - restart already in progress for interface '%s'", req.Interface)
+			respondError(c, http.StatusConflict, errorCodeRestartInProgress, fmt.Sprintf("Restart already in progress for interface '%s'", req.Interface))
+			return
+		}
+		if errors.Is(err, errRestartLimitReached) {
+			logger.Error("API restart request refused for interface '%s': %v", req.Interface, err)
+			respondError(c, http.StatusTooManyRequests, errorCodeRestartLimit, fmt.Sprintf("Refusing to restart interface: %v", err))
+			return
+		}
+
+		logger.Error("API restart request failed for interface '%s': %v", req.Interface, err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    errorCodeRestartFailed,
+			Message: fmt.Sprintf("Failed to restart interface: %v", err),
+			Details: gin.H{"duration_ms": durationMs},
+		})
+		return
 	}
 
-	logger.Info("WireGuard DDNS monitor started")
-	monitor.run(ctx)
+	logger.Info("API restart request completed successfully for interface '%s' in %dms", req.Interface, durationMs)
+	c.JSON(http.StatusOK, RestartResponse{
+		Success:    true,
+		Message:    fmt.Sprintf("Interface '%s' restarted successfully", req.Interface),
+		DurationMs: durationMs,
+	})
 }
 
-func (m *DDNSMonitor) initialize() error {
-	var err error
-	m.conn, err = dbus.NewWithContext(context.Background())
-	if err != nil {
-		return fmt.Errorf("failed to connect to systemd: %w", err)
+// @Summary Restart all monitored interfaces
+// @Description Restart every monitored WireGuard interface, unavailable in single-interface mode
+// @Tags interfaces
+// @Produce json
+// @Param X-API-Key header string true "API Key"
+// @Success 200 {object} RestartAllResponse
+// @Failure 400 {object} ErrorResponse "code is single_interface_mode"
+// @Failure 401 {object} ErrorResponse "code is unauthorized"
+// @Failure 403 {object} ErrorResponse "code is audit_only"
+// @Failure 409 {object} ErrorResponse "code is restart_in_progress when every failure is a restart already in progress, details.results lists the outcome per interface"
+// @Failure 500 {object} ErrorResponse "code is restart_failed, details.results lists the outcome per interface"
+// @Failure 503 {object} ErrorResponse "code is maintenance"
+// @Router /restart-all [post]
+func (m *DDNSMonitor) handleRestartAll(c *gin.Context) {
+	logger.Info("API restart-all request from %s", c.ClientIP())
+
+	if m.auditOnly {
+		logger.Warn("API restart-all request denied - audit-only mode")
+		respondError(c, http.StatusForbidden, errorCodeAuditOnly, "Restarts are disabled in audit-only mode")
+		return
 	}
 
 	if m.singleInterface != "" {
-		return m.parseSingleInterface()
+		logger.Warn("API restart-all request denied - single-interface mode: %s", m.singleInterface)
+		respondError(c, http.StatusBadRequest, errorCodeSingleInterface, "Restart-all is not available in single-interface mode")
+		return
+	}
+
+	var interfaces []string
+	seen := make(map[string]bool)
+	for _, config := range m.snapshot() {
+		if !seen[config.Interface] && allowsInterface(c, config.Interface) {
+			seen[config.Interface] = true
+			interfaces = append(interfaces, config.Interface)
+		}
+	}
+
+	results := make([]InterfaceRestartResult, 0, len(interfaces))
+	failed, inProgress := 0, 0
+	for _, interfaceName := range interfaces {
+		start := time.Now()
+		err := m.restartWireGuardService(c.Request.Context(), interfaceName)
+		result := InterfaceRestartResult{
+			Interface:  interfaceName,
+			Success:    err == nil,
+			Message:    fmt.Sprintf("Interface '%s' restarted successfully", interfaceName),
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+
+		if err != nil {
+			failed++
+			if errors.Is(err, errRestartInProgress) {
+				inProgress++
+				result.Message = fmt.Sprintf("Restart already in progress for interface '%s'", interfaceName)
+			} else if errors.Is(err, errRestartLimitReached) {
+				result.Message = fmt.Sprintf("Refusing to restart interface: %v", err)
+			} else {
+				result.Message = fmt.Sprintf("Failed to restart interface: %v", err)
+			}
+			logger.Error("API restart-all failed for interface '%s': %v", interfaceName, err)
+		}
+
+		results = append(results, result)
+	}
+
+	// Interfaces that are already being restarted are a conflict rather than
+	// a failure, so clients can tell them apart and retry later.
+	if failed > 0 && failed == inProgress {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Code:    errorCodeRestartInProgress,
+			Message: fmt.Sprintf("Restart already in progress for %d of %d interfaces", inProgress, len(results)),
+			Details: gin.H{"results": results},
+		})
+		return
 	}
-	return m.discoverWireGuardConfigs()
+
+	if failed > 0 {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    errorCodeRestartFailed,
+			Message: fmt.Sprintf("%d of %d interfaces failed to restart", failed, len(results)),
+			Details: gin.H{"results": results},
+		})
+		return
+	}
+
+	logger.Info("API restart-all request completed successfully for %d interfaces", len(results))
+	c.JSON(http.StatusOK, RestartAllResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d interfaces restarted successfully", len(results)),
+		Results: results,
+	})
 }
 
-func (m *DDNSMonitor) parseSingleInterface() error {
-	configPath := filepath.Join("/etc/wireguard", m.singleInterface+".conf")
-	if err := m.parseWireGuardConfig(m.singleInterface, configPath); err != nil {
-		return fmt.Errorf("failed to parse config for %s: %w", m.singleInterface, err)
+// @Summary List monitored interfaces
+// @Description Get list of all monitored WireGuard interfaces the API key may act on
+// @Tags interfaces
+// @Produce json
+// @Param X-API-Key header string true "API Key"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} ErrorResponse "code is unauthorized"
+// @Router /interfaces [get]
+func (m *DDNSMonitor) handleListInterfaces(c *gin.Context) {
+	logger.Debug("API interfaces request from %s", c.ClientIP())
+
+	configs := m.allowedConfigs(c)
+	interfaces := make([]map[string]interface{}, 0, len(configs))
+	for _, config := range configs {
+		entry := map[string]interface{}{
+			"interface":    config.Interface,
+			"endpoint":     config.Endpoint,
+			"hostname":     config.Hostname,
+			"last_ip":      normalizeIP(config.LastIP).String(),
+			"change_count": config.ChangeCount,
+			"enabled":      !config.Disabled,
+		}
+		if config.StaticIP != nil {
+			entry["static_endpoint"] = true
+		}
+		if config.Interval > 0 {
+			entry["check_interval"] = config.Interval.String()
+		}
+		if config.Disabled && config.ResolvedIP != nil {
+			entry["resolved_ip"] = ipString(config.ResolvedIP)
+		}
+		if len(config.Addresses) > 0 {
+			addresses := make([]string, 0, len(config.Addresses))
+			for _, ip := range config.Addresses {
+				addresses = append(addresses, ipString(ip))
+			}
+			entry["addresses"] = addresses
+		}
+		if network := m.resolver.Load().preferredNetwork(config.LastIP); network != nil {
+			entry["preferred_cidr"] = network.String()
+		}
+		if m.familyOf(&config) == familyDual {
+			entry["ipv4"] = ipString(config.LastIPv4)
+			entry["ipv6"] = ipString(config.LastIPv6)
+			available := make([]string, 0, 2)
+			if config.LastIPv4 != nil {
+				available = append(available, familyIPv4)
+			}
+			if config.LastIPv6 != nil {
+				available = append(available, familyIPv6)
+			}
+			entry["available_families"] = available
+			if config.LastIP != nil {
+				entry["chosen_family"] = familyIPv6
+				if config.LastIP.To4() != nil {
+					entry["chosen_family"] = familyIPv4
+				}
+			}
+			if config.FamilyReason != "" {
+				entry["family_reason"] = config.FamilyReason
+			}
+		}
+		if current := config.CurrentEndpoint(); current != "" {
+			entry["current_endpoint"] = current
+		}
+		if config.PortOverride != "" {
+			entry["port_override"] = config.PortOverride
+		}
+		if !config.LastChangeAt.IsZero() {
+			entry["last_change_at"] = config.LastChangeAt.Format(time.RFC3339)
+		}
+		if !config.LastCheckAt.IsZero() {
+			entry["last_check_at"] = config.LastCheckAt.Format(time.RFC3339)
+		}
+		if config.BackupHostname != "" {
+			entry["backup_endpoint"] = net.JoinHostPort(config.BackupHostname, config.BackupPort)
+			entry["using_backup"] = config.UsingBackup
+		}
+		interfaces = append(interfaces, entry)
+	}
+
+	response := map[string]interface{}{
+		"single_interface_mode": m.singleInterface != "",
+		"monitored_interface":   m.singleInterface,
+		"interfaces":            interfaces,
+		"total_count":           len(interfaces),
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Discover interfaces
+// @Description Look for active WireGuard interfaces now, start monitoring new ones and stop monitoring those that are gone. Interfaces that are still active keep their state.
+// @Tags interfaces
+// @Produce json
+// @Param X-API-Key header string true "API Key"
+// @Success 200 {object} DiscoverResponse
+// @Failure 400 {object} ErrorResponse "code is single_interface_mode"
+// @Failure 401 {object} ErrorResponse "code is unauthorized"
+// @Failure 403 {object} ErrorResponse "code is scope_forbidden"
+// @Failure 500 {object} ErrorResponse "code is discovery_failed"
+// @Failure 503 {object} ErrorResponse "code is maintenance"
+// @Router /discover [post]
+func (m *DDNSMonitor) handleDiscover(c *gin.Context) {
+	logger.Info("API discover request from %s", c.ClientIP())
+
+	if _, scoped := c.Get(apiKeyScopeKey); scoped {
+		logger.Warn("API discover request denied - API key is limited to some interfaces")
+		respondError(c, http.StatusForbidden, errorCodeScopeForbidden, "API key is limited to some interfaces and cannot change which interfaces are monitored")
+		return
+	}
+
+	if m.singleInterface != "" {
+		respondError(c, http.StatusBadRequest, errorCodeSingleInterface, "Discovery is not available in single-interface mode")
+		return
+	}
+
+	added, removed, err := m.rediscover()
+	if err != nil {
+		logger.Error("API discover request failed: %v", err)
+		respondError(c, http.StatusInternalServerError, errorCodeDiscoveryFailed, fmt.Sprintf("Failed to discover interfaces: %v", err))
+		return
+	}
+
+	if added == nil {
+		added = []string{}
+	}
+	if removed == nil {
+		removed = []string{}
+	}
+	c.JSON(http.StatusOK, DiscoverResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d interface(s) added, %d removed", len(added), len(removed)),
+		Added:   added,
+		Removed: removed,
+	})
+}
+
+// @Summary List discovered interfaces
+// @Description Get all active WireGuard interfaces the API key may act on and whether each one is monitored
+// @Tags interfaces
+// @Produce json
+// @Param X-API-Key header string true "API Key"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} ErrorResponse "code is unauthorized"
+// @Failure 500 {object} ErrorResponse "code is discovery_failed"
+// @Router /discovered [get]
+func (m *DDNSMonitor) handleListDiscovered(c *gin.Context) {
+	logger.Debug("API discovered request from %s", c.ClientIP())
+
+	names, err := listActiveWireGuardInterfaces(m.systemd(), m.unitPrefix)
+	if err != nil {
+		logger.Error("API discovered request failed: %v", err)
+		respondError(c, http.StatusInternalServerError, errorCodeDiscoveryFailed, fmt.Sprintf("Failed to list interfaces: %v", err))
+		return
+	}
+
+	monitored := make(map[string]bool)
+	for _, config := range m.snapshot() {
+		monitored[config.Interface] = true
+	}
+
+	interfaces := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		if !allowsInterface(c, name) {
+			continue
+		}
+		entry := map[string]interface{}{
+			"interface": name,
+			"monitored": monitored[name],
+		}
+		if !monitored[name] {
+			entry["reason"] = m.exclusionReason(name)
+		}
+		interfaces = append(interfaces, entry)
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"interfaces":  interfaces,
+		"total_count": len(interfaces),
+	})
+}
+
+func (m *DDNSMonitor) exclusionReason(interfaceName string) string {
+	if m.singleInterface != "" && interfaceName != m.singleInterface {
+		return fmt.Sprintf("single-interface mode monitors only '%s'", m.singleInterface)
+	}
+	if !matchesNamePattern(m.namePattern, interfaceName) {
+		return "does not match --interface-name-pattern"
+	}
+	if !m.interfaceFilter.Match(interfaceName) {
+		return "does not match interface filter"
+	}
+
+	configPath := filepath.Join("/etc/wireguard", interfaceName+".conf")
+	configs, err := readWireGuardConfig(interfaceName, configPath, m.endpointSelection, m.monitorHostnames)
+	if err != nil && !isPartialRead(err) {
+		return fmt.Sprintf("failed to parse config: %v", err)
+	}
+	if len(configs) == 0 {
+		return "no domain endpoints"
 	}
+	return "not present at discovery time"
+}
 
-	logger.Info("Monitoring single interface: %s with %d domain endpoints", m.singleInterface, len(m.configs))
-	return nil
+// @Summary Get monitor metrics
+// @Description Get monitor loop timings and per-endpoint change and failure counts in the Prometheus text format
+// @Tags status
+// @Produce plain
+// @Param X-API-Key header string true "API Key"
+// @Success 200 {string} string
+// @Failure 401 {object} ErrorResponse "code is unauthorized"
+// @Router /metrics [get]
+func (m *DDNSMonitor) handleMetrics(c *gin.Context) {
+	configs := m.allowedConfigs(c)
+	var b strings.Builder
+	writeMetric(&b, "wgddns_loop_lag_seconds", "gauge",
+		"Delay between the intended and the actual start of the last endpoint check.",
+		time.Duration(m.loopLag.Load()).Seconds())
+	writeMetric(&b, "wgddns_check_duration_seconds", "gauge",
+		"Duration of the last endpoint check.",
+		time.Duration(m.checkDuration.Load()).Seconds())
+	writeMetric(&b, "wgddns_check_overruns_total", "counter",
+		"Endpoint checks that took longer than the check interval.",
+		float64(m.checkOverruns.Load()))
+	writeEndpointMetric(&b, "wgddns_endpoint_changes_total", "counter",
+		"IP changes detected for the endpoint since the daemon started.",
+		configs, func(config *Config) float64 { return float64(config.ChangeCount) })
+	writeEndpointMetric(&b, "wgddns_endpoint_consecutive_failures", "gauge",
+		"Consecutive failed lookups of the endpoint hostname.",
+		configs, func(config *Config) float64 { return float64(config.ConsecutiveFailures) })
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
 }
 
-func (m *DDNSMonitor) cleanup() {
-	if m.httpServer != nil {
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		m.httpServer.Shutdown(shutdownCtx)
-	}
-	if m.conn != nil {
-		m.conn.Close()
+func writeMetric(b *strings.Builder, name, kind, help string, value float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, kind)
+	fmt.Fprintf(b, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeEndpointMetric writes one sample per endpoint, labelled with its
+// interface, hostname and the labels from its config.
+func writeEndpointMetric(b *strings.Builder, name, kind, help string, configs []Config, value func(*Config) float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, kind)
+	for i := range configs {
+		config := &configs[i]
+		fmt.Fprintf(b, "%s{interface=\"%s\",hostname=\"%s\"", name,
+			labelValueEscaper.Replace(config.Interface), labelValueEscaper.Replace(config.Hostname))
+		keys := make([]string, 0, len(config.Labels))
+		for key := range config.Labels {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			fmt.Fprintf(b, ",%s=\"%s\"", key, labelValueEscaper.Replace(config.Labels[key]))
+		}
+		fmt.Fprintf(b, "} %s\n", strconv.FormatFloat(value(config), 'g', -1, 64))
 	}
 }
 
-func (m *DDNSMonitor) discoverWireGuardConfigs() error {
-	units, err := m.conn.ListUnitsContext(context.Background())
-	if err != nil {
-		return fmt.Errorf("failed to list systemd units: %w", err)
+// @Summary Get recent log lines
+// @Description Get the most recent log entries kept in memory, oldest first
+// @Tags logs
+// @Produce json
+// @Param X-API-Key header string true "API Key"
+// @Param lines query int false "Maximum number of entries (default 100)"
+// @Param level query string false "Minimum level: debug, info, warn, error"
+// @Success 200 {object} LogsResponse
+// @Failure 400 {object} ErrorResponse "code is invalid_parameter"
+// @Failure 401 {object} ErrorResponse "code is unauthorized"
+// @Failure 403 {object} ErrorResponse "code is scope_forbidden"
+// @Failure 404 {object} ErrorResponse "code is feature_disabled"
+// @Router /logs [get]
+func (m *DDNSMonitor) handleLogs(c *gin.Context) {
+	// Log lines are not attributed to interfaces, so they cannot be
+	// narrowed down to the interfaces of a scoped key.
+	if _, scoped := c.Get(apiKeyScopeKey); scoped {
+		logger.Warn("API logs request denied - API key is limited to some interfaces")
+		respondError(c, http.StatusForbidden, errorCodeScopeForbidden, "API key is limited to some interfaces and cannot read the logs of all interfaces")
+		return
 	}
 
-	for _, unit := range units {
-		if strings.HasPrefix(unit.Name, "wg-quick@") && strings.HasSuffix(unit.Name, ".service") && unit.ActiveState == "active" {
-			interfaceName := strings.TrimPrefix(unit.Name, "wg-quick@")
-			interfaceName = strings.TrimSuffix(interfaceName, ".service")
+	if logger.recent == nil {
+		respondError(c, http.StatusNotFound, errorCodeFeatureDisabled, "Log buffer is disabled (--log-buffer-size 0)")
+		return
+	}
 
-			configPath := filepath.Join("/etc/wireguard", interfaceName+".conf")
-			if err := m.parseWireGuardConfig(interfaceName, configPath); err != nil {
-				logger.Warn("Failed to parse config for %s: %v", interfaceName, err)
-				continue
-			}
+	lines := 100
+	if value := c.Query("lines"); value != "" {
+		var err error
+		lines, err = strconv.Atoi(value)
+		if err != nil || lines < 1 {
+			respondError(c, http.StatusBadRequest, errorCodeInvalidParameter, fmt.Sprintf("Invalid lines value '%s', must be a positive integer", value))
+			return
 		}
 	}
 
-	logger.Info("Discovered %d WireGuard interfaces with domain endpoints", len(m.configs))
-	return nil
+	minLevel := DEBUG
+	switch level := strings.ToLower(c.Query("level")); level {
+	case "":
+	case "debug", "info", "warn", "warning", "error":
+		minLevel = parseLogLevel(level)
+	default:
+		respondError(c, http.StatusBadRequest, errorCodeInvalidParameter, fmt.Sprintf("Invalid level '%s', must be one of: debug, info, warn, error", level))
+		return
+	}
+
+	c.JSON(http.StatusOK, LogsResponse{Entries: logger.recent.Recent(lines, minLevel)})
 }
 
-func (m *DDNSMonitor) parseWireGuardConfig(interfaceName, configPath string) error {
-	file, err := os.Open(configPath)
-	if err != nil {
-		return fmt.Errorf("failed to open config file %s: %w", configPath, err)
-	}
-	defer file.Close()
+// @Summary Preview endpoint changes
+// @Description Resolve every endpoint now, bypassing --dns-cache-ttl, and report what a check would do, without changing anything: restart the interface, defer the restart because of maintenance mode, the startup grace period or --manual-restart-cooldown, update a static endpoint with wg set, or nothing. Confirmations pending under --change-window are not taken into account.
+// @Tags status
+// @Produce json
+// @Param X-API-Key header string true "API Key"
+// @Success 200 {object} PreviewResponse
+// @Failure 401 {object} ErrorResponse "code is unauthorized"
+// @Router /preview [get]
+func (m *DDNSMonitor) handlePreview(c *gin.Context) {
+	logger.Debug("API preview request from %s", c.ClientIP())
 
-	scanner := bufio.NewScanner(file)
-	endpointRegex := regexp.MustCompile(`^\s*Endpoint\s*=\s*(.+)$`)
-	ipRegex := regexp.MustCompile(`^\d+\.\d+\.\d+\.\d+`)
+	// The lookups bypass --dns-cache-ttl, so that a preview neither shows
+	// cached answers nor changes the ones the next check will use.
+	resolver := *m.resolver.Load()
+	resolver.cache = nil
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		matches := endpointRegex.FindStringSubmatch(line)
-		if len(matches) == 2 {
-			endpoint := strings.TrimSpace(matches[1])
+	configs := m.allowedConfigs(c)
+	response := PreviewResponse{
+		Restarts:  []string{},
+		Endpoints: make([]PreviewEndpoint, 0, len(configs)),
+	}
+	pending := make(map[string]bool)
+	deferrals := make(map[string]string)
+	live := make(map[string]map[string]string)
 
-			host, _, err := net.SplitHostPort(endpoint)
-			if err != nil {
-				continue
-			}
+	for i := range configs {
+		config := &configs[i]
+		entry := PreviewEndpoint{
+			Interface: config.Interface,
+			Hostname:  config.Hostname,
+			OldIP:     ipString(config.LastIP),
+			Action:    previewActionNone,
+		}
 
-			if !ipRegex.MatchString(host) {
-				config := Config{
-					Interface: interfaceName,
-					Endpoint:  endpoint,
-					Hostname:  host,
+		result, err := resolver.ResolveFamily(c.Request.Context(), config.Hostname, config.Family)
+		switch {
+		case err != nil:
+			entry.Action = previewActionUnresolved
+			entry.Error = err.Error()
+		case config.Disabled:
+			entry.NewIP = ipString(result.Chosen)
+			entry.Action = previewActionDisabled
+		case config.StaticIP != nil:
+			entry.NewIP = ipString(result.Chosen)
+			endpoints, ok := live[config.Interface]
+			if !ok {
+				endpoints, err = readLivePeerEndpoints(config.Interface)
+				if err != nil {
+					entry.Error = err.Error()
 				}
-
-				if ip, err := net.ResolveIPAddr("ip4", host); err == nil {
-					config.LastIP = ip.IP
+				live[config.Interface] = endpoints
+			}
+			if endpoints != nil {
+				entry.OldIP = endpoints[config.PublicKey]
+				if host, _, err := net.SplitHostPort(entry.OldIP); err == nil {
+					entry.OldIP = host
 				}
-
-				m.configs = append(m.configs, config)
-				logger.Debug("Found domain endpoint: %s -> %s (interface: %s)", host, config.LastIP, interfaceName)
+				if endpoints[config.PublicKey] != net.JoinHostPort(result.Chosen.String(), config.EndpointPort()) {
+					entry.Action = previewActionUpdate
+				}
+			}
+		default:
+			entry.NewIP = ipString(result.Chosen)
+			if !config.UsingBackup && !m.endpointChanged(config, result) {
+				break
+			}
+			if !pending[config.Interface] {
+				pending[config.Interface] = true
+				deferrals[config.Interface] = m.restartDeferral(c.Request.Context(), config.Interface)
+				if deferrals[config.Interface] == "" {
+					response.Restarts = append(response.Restarts, config.Interface)
+				}
+			}
+			entry.Action = previewActionRestart
+			if reason := deferrals[config.Interface]; reason != "" {
+				entry.Action = previewActionDeferred
+				entry.Reason = reason
 			}
 		}
+
+		response.Endpoints = append(response.Endpoints, entry)
 	}
 
-	return scanner.Err()
+	c.JSON(http.StatusOK, response)
 }
 
-func (m *DDNSMonitor) checkEndpoints() {
-	for i := range m.configs {
-		config := &m.configs[i]
-
-		logger.Debug("Resolving DNS for %s (interface: %s)", config.Hostname, config.Interface)
-		currentIP, err := net.ResolveIPAddr("ip4", config.Hostname)
-		if err != nil {
-			logger.Warn("Failed to resolve %s: %v", config.Hostname, err)
-			continue
-		}
+// @Summary Flush DNS cache
+// @Description Drop every lookup cached under --dns-cache-ttl so that the next check resolves all endpoints afresh
+// @Tags status
+// @Produce json
+// @Param X-API-Key header string true "API Key"
+// @Success 200 {object} RestartResponse
+// @Failure 401 {object} ErrorResponse "code is unauthorized"
+// @Failure 403 {object} ErrorResponse "code is scope_forbidden"
+// @Failure 404 {object} ErrorResponse "code is feature_disabled"
+// @Failure 503 {object} ErrorResponse "code is maintenance"
+// @Router /dns-cache/flush [post]
+func (m *DDNSMonitor) handleFlushDNSCache(c *gin.Context) {
+	if _, scoped := c.Get(apiKeyScopeKey); scoped {
+		logger.Warn("API DNS cache flush request denied - API key is limited to some interfaces")
+		respondError(c, http.StatusForbidden, errorCodeScopeForbidden, "API key is limited to some interfaces and cannot flush the DNS cache shared by all interfaces")
+		return
+	}
 
-		logger.Debug("DNS resolution result for %s: %s (interface: %s)", config.Hostname, currentIP.IP, config.Interface)
+	if m.dnsCache == nil {
+		respondError(c, http.StatusNotFound, errorCodeFeatureDisabled, "DNS cache is disabled, enable it with --dns-cache-ttl")
+		return
+	}
 
-		if !config.LastIP.Equal(currentIP.IP) {
-			logger.Warn("IP change detected for %s: %s -> %s (interface: %s)",
-				config.Hostname, config.LastIP, currentIP.IP, config.Interface)
+	flushed := m.dnsCache.Flush()
+	logger.Info("API DNS cache flushed from %s, dropped %d lookup(s)", c.ClientIP(), flushed)
+	c.JSON(http.StatusOK, RestartResponse{
+		Success: true,
+		Message: fmt.Sprintf("DNS cache flushed, dropped %d lookup(s)", flushed),
+	})
+}
 
-			config.LastIP = currentIP.IP
+// @Summary Get monitor status
+// @Description Get the monitored endpoint counts, readiness and the state of the restart circuit breaker
+// @Tags status
+// @Produce json
+// @Param X-API-Key header string true "API Key"
+// @Success 200 {object} StatusResponse
+// @Failure 401 {object} ErrorResponse "code is unauthorized"
+// @Router /status [get]
+func (m *DDNSMonitor) handleStatus(c *gin.Context) {
+	logger.Debug("API status request from %s", c.ClientIP())
 
-			if err := m.restartWireGuardService(config.Interface); err != nil {
-				logger.Error("Failed to restart wg-quick@%s: %v", config.Interface, err)
-			} else {
-				logger.Warn("Successfully restarted wg-quick@%s.service", config.Interface)
-			}
+	disabled := 0
+	configs := m.allowedConfigs(c)
+	for _, config := range configs {
+		if config.Disabled {
+			disabled++
 		}
 	}
+
+	c.JSON(http.StatusOK, StatusResponse{
+		SingleInterfaceMode: m.singleInterface != "",
+		MonitoredCount:      len(configs),
+		DisabledCount:       disabled,
+		Ready:               m.ready.Load(),
+		Maintenance:         m.maintenance.Load(),
+		RestartBreaker:      m.restartBreakerStatus(),
+	})
 }
 
-func (m *DDNSMonitor) restartWireGuardService(interfaceName string) error {
-	serviceName := fmt.Sprintf("wg-quick@%s.service", interfaceName)
+// @Summary Toggle maintenance mode
+// @Description Pause all checks and restarts while enabled, the other mutating endpoints answer 503 meanwhile. The setting is kept in the state file.
+// @Tags status
+// @Accept json
+// @Produce json
+// @Param X-API-Key header string true "API Key"
+// @Param request body MaintenanceRequest true "Maintenance mode request"
+// @Success 200 {object} RestartResponse
+// @Failure 400 {object} ErrorResponse "code is empty_body, malformed_json, invalid_field or missing_field"
+// @Failure 401 {object} ErrorResponse "code is unauthorized"
+// @Failure 403 {object} ErrorResponse "code is scope_forbidden"
+// @Failure 413 {object} ErrorResponse "code is body_too_large"
+// @Failure 500 {object} ErrorResponse "code is persist_failed"
+// @Router /maintenance [post]
+func (m *DDNSMonitor) handleMaintenance(c *gin.Context) {
+	var req MaintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondError(c, http.StatusRequestEntityTooLarge, errorCodeBodyTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+			return
+		}
 
-	reschan := make(chan string)
-	_, err := m.conn.RestartUnitContext(context.Background(), serviceName, "replace", reschan)
-	if err != nil {
-		return fmt.Errorf("failed to restart service %s: %w", serviceName, err)
+		code, message := describeBindingError(err, &req)
+		logger.Debug("API maintenance request - %s from %s: %v", code, c.ClientIP(), err)
+		respondError(c, http.StatusBadRequest, code, message)
+		return
 	}
 
-	job := <-reschan
-	if job != "done" {
-		return fmt.Errorf("service restart job failed: %s", job)
+	if _, scoped := c.Get(apiKeyScopeKey); scoped {
+		logger.Warn("API maintenance request denied - API key is limited to some interfaces")
+		respondError(c, http.StatusForbidden, errorCodeScopeForbidden, "API key is limited to some interfaces and cannot toggle maintenance mode")
+		return
 	}
 
-	return nil
-}
-
-func (m *DDNSMonitor) startHTTPServer(ctx context.Context) {
-	gin.SetMode(gin.ReleaseMode)
-	router := gin.New()
-	router.Use(gin.Recovery())
-	router.Use(m.loggingMiddleware())
+	action := "off"
+	if *req.Enabled {
+		action = "on"
+	}
+	if m.maintenance.Swap(*req.Enabled) != *req.Enabled {
+		logger.Warn("API maintenance mode turned %s from %s", action, c.ClientIP())
+	}
 
-	v1 := router.Group("/api/v1")
-	v1.Use(m.authMiddleware())
-	{
-		v1.POST("/restart", m.handleRestart)
-		v1.GET("/interfaces", m.handleListInterfaces)
+	if err := m.persistState(); err != nil {
+		logger.Error("Failed to persist state: %v", err)
+		respondError(c, http.StatusInternalServerError, errorCodePersistFailed, fmt.Sprintf("Maintenance mode is %s but failed to persist state: %v", action, err))
+		return
 	}
 
-	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	c.JSON(http.StatusOK, RestartResponse{
+		Success: true,
+		Message: fmt.Sprintf("Maintenance mode is %s", action),
+	})
+}
 
-	addr := fmt.Sprintf("%s:%s", m.listenAddress, m.listenPort)
-	m.httpServer = &http.Server{
-		Addr:    addr,
-		Handler: router,
+// @Summary Reset change statistics
+// @Description Reset the per-endpoint IP change counters
+// @Tags interfaces
+// @Produce json
+// @Param X-API-Key header string true "API Key"
+// @Success 200 {object} RestartResponse
+// @Failure 401 {object} ErrorResponse "code is unauthorized"
+// @Failure 503 {object} ErrorResponse "code is maintenance"
+// @Router /stats/reset [post]
+func (m *DDNSMonitor) handleResetStats(c *gin.Context) {
+	m.cycleMu.Lock()
+	for i := range m.configs {
+		if !allowsInterface(c, m.configs[i].Interface) {
+			continue
+		}
+		m.configs[i].ChangeCount = 0
+		m.configs[i].LastChangeAt = time.Time{}
 	}
+	m.publishConfigs()
+	m.cycleMu.Unlock()
 
-	logger.Info("HTTP API server started on %s", addr)
-	logger.Info("Swagger UI available at http://%s/swagger/index.html", addr)
+	logger.Info("API change statistics reset from %s", c.ClientIP())
+	c.JSON(http.StatusOK, RestartResponse{
+		Success: true,
+		Message: "Change statistics reset",
+	})
+}
 
-	go func() {
-		if err := m.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Error("HTTP server error: %v", err)
-		}
-	}()
+// @Summary Disable endpoint monitoring
+// @Description Stop acting on IP changes of an interface's endpoints. Disabled endpoints are still resolved for display and the setting is kept in the state file.
+// @Tags interfaces
+// @Produce json
+// @Param X-API-Key header string true "API Key"
+// @Param name path string true "Interface name"
+// @Param hostname query string false "Only disable the endpoint with this hostname"
+// @Success 200 {object} RestartResponse
+// @Failure 400 {object} ErrorResponse "code is invalid_interface"
+// @Failure 401 {object} ErrorResponse "code is unauthorized"
+// @Failure 403 {object} ErrorResponse "code is interface_forbidden"
+// @Failure 404 {object} ErrorResponse "code is interface_not_found"
+// @Failure 500 {object} ErrorResponse "code is persist_failed"
+// @Failure 503 {object} ErrorResponse "code is maintenance"
+// @Router /interfaces/{name}/disable [post]
+func (m *DDNSMonitor) handleDisableInterface(c *gin.Context) {
+	m.setEndpointsDisabled(c, true)
+}
 
-	<-ctx.Done()
+// @Summary Enable endpoint monitoring
+// @Description Resume acting on IP changes of an interface's endpoints
+// @Tags interfaces
+// @Produce json
+// @Param X-API-Key header string true "API Key"
+// @Param name path string true "Interface name"
+// @Param hostname query string false "Only enable the endpoint with this hostname"
+// @Success 200 {object} RestartResponse
+// @Failure 400 {object} ErrorResponse "code is invalid_interface"
+// @Failure 401 {object} ErrorResponse "code is unauthorized"
+// @Failure 403 {object} ErrorResponse "code is interface_forbidden"
+// @Failure 404 {object} ErrorResponse "code is interface_not_found"
+// @Failure 500 {object} ErrorResponse "code is persist_failed"
+// @Failure 503 {object} ErrorResponse "code is maintenance"
+// @Router /interfaces/{name}/enable [post]
+func (m *DDNSMonitor) handleEnableInterface(c *gin.Context) {
+	m.setEndpointsDisabled(c, false)
 }
 
-func (m *DDNSMonitor) loggingMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		start := time.Now()
+func (m *DDNSMonitor) setEndpointsDisabled(c *gin.Context, disabled bool) {
+	interfaceName := c.Param("name")
+	hostname := c.Query("hostname")
 
-		c.Next()
+	if err := checkInterfaceName(m.namePattern, interfaceName); err != nil {
+		respondInvalidInterface(c, err)
+		return
+	}
 
-		duration := time.Since(start)
-		clientIP := c.ClientIP()
-		method := c.Request.Method
-		path := c.Request.URL.Path
-		statusCode := c.Writer.Status()
+	if !allowsInterface(c, interfaceName) {
+		respondInterfaceForbidden(c, interfaceName)
+		return
+	}
 
-		logger.Info("API %s %s - %d - %v - %s", method, path, statusCode, duration, clientIP)
+	action := "enabled"
+	if disabled {
+		action = "disabled"
 	}
-}
 
-func (m *DDNSMonitor) authMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		apiKey := c.GetHeader("X-API-Key")
-		if apiKey != m.apiKey {
-			logger.Warn("API authentication failed from %s", c.ClientIP())
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
-			c.Abort()
-			return
+	m.cycleMu.Lock()
+	matched := 0
+	for i := range m.configs {
+		config := &m.configs[i]
+		if config.Interface != interfaceName || hostname != "" && config.Hostname != hostname {
+			continue
 		}
-		c.Next()
+		config.Disabled = disabled
+		matched++
+		logger.Info("API monitoring of %s %s from %s (interface: %s)", config.Hostname, action, c.ClientIP(), interfaceName)
+	}
+	m.publishConfigs()
+	m.cycleMu.Unlock()
+
+	if matched == 0 {
+		message := fmt.Sprintf("Interface '%s' not found in monitored interfaces", interfaceName)
+		if hostname != "" {
+			message = fmt.Sprintf("Endpoint '%s' not found on interface '%s'", hostname, interfaceName)
+		}
+		respondError(c, http.StatusNotFound, errorCodeInterfaceNotFound, message)
+		return
+	}
+
+	if err := m.persistState(); err != nil {
+		logger.Error("Failed to persist state: %v", err)
+		respondError(c, http.StatusInternalServerError, errorCodePersistFailed, fmt.Sprintf("Monitoring %s for %d endpoint(s) but failed to persist state: %v", action, matched, err))
+		return
 	}
+
+	c.JSON(http.StatusOK, RestartResponse{
+		Success: true,
+		Message: fmt.Sprintf("Monitoring %s for %d endpoint(s) on interface '%s'", action, matched, interfaceName),
+	})
 }
 
-// @Summary Restart WireGuard interface
-// @Description Restart a specific WireGuard interface
+// @Summary Override endpoint port
+// @Description Use another port than the configured one whenever an endpoint of the interface is updated, port 0 removes the override
 // @Tags interfaces
 // @Accept json
 // @Produce json
 // @Param X-API-Key header string true "API Key"
-// @Param request body RestartRequest true "Interface to restart"
+// @Param name path string true "Interface name"
+// @Param hostname query string false "Only override the port of the endpoint with this hostname"
+// @Param request body PortOverrideRequest true "Port override request"
 // @Success 200 {object} RestartResponse
-// @Failure 400 {object} RestartResponse
-// @Failure 401 {object} RestartResponse
-// @Failure 404 {object} RestartResponse
-// @Failure 500 {object} RestartResponse
-// @Router /restart [post]
-func (m *DDNSMonitor) handleRestart(c *gin.Context) {
-	var req RestartRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		logger.Debug("API restart request - invalid JSON from %s", c.ClientIP())
-		c.JSON(http.StatusBadRequest, RestartResponse{
-			Success: false,
-			Message: "Invalid request format",
-		})
+// @Failure 400 {object} ErrorResponse "code is empty_body, malformed_json, invalid_field, missing_field or invalid_interface"
+// @Failure 401 {object} ErrorResponse "code is unauthorized"
+// @Failure 403 {object} ErrorResponse "code is interface_forbidden"
+// @Failure 404 {object} ErrorResponse "code is interface_not_found"
+// @Failure 413 {object} ErrorResponse "code is body_too_large"
+// @Failure 500 {object} ErrorResponse "code is persist_failed"
+// @Failure 503 {object} ErrorResponse "code is maintenance"
+// @Router /interfaces/{name}/port [post]
+func (m *DDNSMonitor) handleSetPortOverride(c *gin.Context) {
+	interfaceName := c.Param("name")
+	hostname := c.Query("hostname")
+
+	if err := checkInterfaceName(m.namePattern, interfaceName); err != nil {
+		respondInvalidInterface(c, err)
 		return
 	}
 
-	logger.Info("API restart request for interface '%s' from %s", req.Interface, c.ClientIP())
+	if !allowsInterface(c, interfaceName) {
+		respondInterfaceForbidden(c, interfaceName)
+		return
+	}
 
-	if m.singleInterface != "" && req.Interface != m.singleInterface {
-		logger.Warn("API restart request denied - interface '%s' not allowed (single-interface mode: %s)", req.Interface, m.singleInterface)
-		c.JSON(http.StatusBadRequest, RestartResponse{
-			Success: false,
-			Message: fmt.Sprintf("Only interface '%s' is monitored", m.singleInterface),
-		})
+	var req PortOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondError(c, http.StatusRequestEntityTooLarge, errorCodeBodyTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+			return
+		}
+
+		code, message := describeBindingError(err, &req)
+		logger.Debug("API port override request - %s from %s: %v", code, c.ClientIP(), err)
+		respondError(c, http.StatusBadRequest, code, message)
 		return
 	}
 
-	found := false
-	for _, config := range m.configs {
-		if config.Interface == req.Interface {
-			found = true
-			break
+	port := ""
+	if *req.Port != 0 {
+		port = strconv.Itoa(*req.Port)
+	}
+
+	m.cycleMu.Lock()
+	matched := 0
+	for i := range m.configs {
+		config := &m.configs[i]
+		if config.Interface != interfaceName || hostname != "" && config.Hostname != hostname {
+			continue
+		}
+		config.PortOverride = port
+		matched++
+		if port == "" {
+			logger.Info("API port override of %s removed from %s (interface: %s)", config.Hostname, c.ClientIP(), interfaceName)
+		} else {
+			logger.Info("API port override of %s set to %s from %s (interface: %s)", config.Hostname, port, c.ClientIP(), interfaceName)
 		}
 	}
+	m.publishConfigs()
+	m.cycleMu.Unlock()
 
-	if !found {
-		logger.Warn("API restart request denied - interface '%s' not found in monitored interfaces", req.Interface)
-		c.JSON(http.StatusNotFound, RestartResponse{
-			Success: false,
-			Message: fmt.Sprintf("Interface '%s' not found in monitored interfaces", req.Interface),
-		})
+	if matched == 0 {
+		message := fmt.Sprintf("Interface '%s' not found in monitored interfaces", interfaceName)
+		if hostname != "" {
+			message = fmt.Sprintf("Endpoint '%s' not found on interface '%s'", hostname, interfaceName)
+		}
+		respondError(c, http.StatusNotFound, errorCodeInterfaceNotFound, message)
 		return
 	}
 
-	if err := m.restartWireGuardService(req.Interface); err != nil {
-		logger.Error("API restart request failed for interface '%s': %v", req.Interface, err)
-		c.JSON(http.StatusInternalServerError, RestartResponse{
-			Success: false,
-			Message: fmt.Sprintf("Failed to restart interface: %v", err),
-		})
+	message := fmt.Sprintf("Port override set to %s for %d endpoint(s) on interface '%s', applied on the next update", port, matched, interfaceName)
+	if port == "" {
+		message = fmt.Sprintf("Port override removed for %d endpoint(s) on interface '%s'", matched, interfaceName)
+	}
+
+	if err := m.persistState(); err != nil {
+		logger.Error("Failed to persist state: %v", err)
+		respondError(c, http.StatusInternalServerError, errorCodePersistFailed, fmt.Sprintf("%s but failed to persist state: %v", message, err))
 		return
 	}
 
-	logger.Info("API restart request completed successfully for interface '%s'", req.Interface)
 	c.JSON(http.StatusOK, RestartResponse{
 		Success: true,
-		Message: fmt.Sprintf("Interface '%s' restarted successfully", req.Interface),
+		Message: message,
 	})
 }
 
-// @Summary List monitored interfaces
-// @Description Get list of all monitored WireGuard interfaces
-// @Tags interfaces
-// @Produce json
-// @Param X-API-Key header string true "API Key"
-// @Success 200 {object} map[string]interface{}
-// @Failure 401 {object} map[string]interface{}
-// @Router /interfaces [get]
-func (m *DDNSMonitor) handleListInterfaces(c *gin.Context) {
-	logger.Debug("API interfaces request from %s", c.ClientIP())
-
-	interfaces := make([]map[string]interface{}, 0, len(m.configs))
-	for _, config := range m.configs {
-		interfaces = append(interfaces, map[string]interface{}{
-			"interface": config.Interface,
-			"endpoint":  config.Endpoint,
-			"hostname":  config.Hostname,
-			"last_ip":   config.LastIP.String(),
-		})
-	}
-
-	response := map[string]interface{}{
-		"single_interface_mode": m.singleInterface != "",
-		"monitored_interface":   m.singleInterface,
-		"interfaces":            interfaces,
-		"total_count":           len(interfaces),
-	}
+func respondInterfaceForbidden(c *gin.Context, interfaceName string) {
+	logger.Warn("API request from %s denied - API key not allowed to act on interface '%s'", c.ClientIP(), interfaceName)
+	respondError(c, http.StatusForbidden, errorCodeInterfaceForbidden, fmt.Sprintf("API key is not allowed to act on interface '%s'", interfaceName))
+}
 
-	c.JSON(http.StatusOK, response)
+func respondInvalidInterface(c *gin.Context, err error) {
+	logger.Warn("API request from %s rejected - %v", c.ClientIP(), err)
+	respondError(c, http.StatusBadRequest, errorCodeInvalidInterface, fmt.Sprintf("Invalid interface: %v", err))
 }
 
 func (m *DDNSMonitor) run(ctx context.Context) {
 	logger.Info("DNS check interval: %v", m.checkInterval)
-	ticker := time.NewTicker(m.checkInterval)
+	tick := m.checkInterval
+	if m.adaptive() {
+		logger.Info("Adapting per-endpoint check intervals between %v and %v", m.minInterval, m.maxInterval)
+		tick = m.minInterval
+	}
+	m.startedAt = time.Now()
+	if m.startupGrace > 0 {
+		logger.Info("Restarts are suppressed for the first %v after startup", m.startupGrace)
+	}
+	if m.coalesceWindow > 0 {
+		logger.Info("Restarts wait %v to coalesce further changes", m.coalesceWindow)
+	}
+	if m.maxRestartsHour > 0 {
+		logger.Info("Refusing restarts beyond %d per hour", m.maxRestartsHour)
+	}
+	if m.auditOnly {
+		logger.Info("Audit-only mode: recording resolved addresses without restarting interfaces")
+	}
+	if m.maintenance.Load() {
+		logger.Warn("Maintenance mode is on, checks are paused until it is turned off through the API")
+	}
+	if m.checkOffset > 0 {
+		logger.Info("Delaying scheduled checks by %v", m.checkOffset)
+		select {
+		case <-ctx.Done():
+			logger.Info("Shutting down monitor")
+			return
+		case <-time.After(m.checkOffset):
+		}
+	}
+	ticker := time.NewTicker(tick)
 	defer ticker.Stop()
 
 	for {
@@ -744,9 +6590,22 @@ func (m *DDNSMonitor) run(ctx context.Context) {
 		case <-ctx.Done():
 			logger.Info("Shutting down monitor")
 			return
-		case <-ticker.C:
+		case intended := <-ticker.C:
+			if m.maintenance.Load() {
+				logger.Debug("Skipping scheduled endpoint check: maintenance mode is on")
+				continue
+			}
+			start := time.Now()
+			m.loopLag.Store(int64(start.Sub(intended)))
 			logger.Debug("Starting scheduled endpoint check")
-			m.checkEndpoints()
+			m.checkEndpoints(ctx)
+			m.updateReadiness()
+			elapsed := time.Since(start)
+			m.checkDuration.Store(int64(elapsed))
+			if elapsed > tick {
+				m.checkOverruns.Add(1)
+				logger.Warn("Endpoint check took %v, longer than the %v interval", elapsed.Round(time.Millisecond), tick)
+			}
 			logger.Debug("Completed scheduled endpoint check")
 		}
 	}
@@ -0,0 +1,1082 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestMain(m *testing.M) {
+	logger = &Logger{level: ERROR}
+	os.Exit(m.Run())
+}
+
+func TestNormalizeIPv4Mapped(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		size int
+	}{
+		{"::ffff:192.0.2.1", "192.0.2.1", net.IPv4len},
+		{"192.0.2.1", "192.0.2.1", net.IPv4len},
+		{"2001:db8::1", "2001:db8::1", net.IPv6len},
+	}
+
+	for _, tt := range tests {
+		ip := normalizeIP(net.ParseIP(tt.in))
+		if len(ip) != tt.size {
+			t.Errorf("normalizeIP(%s) has length %d, want %d", tt.in, len(ip), tt.size)
+		}
+		if got := ipString(net.ParseIP(tt.in)); got != tt.want {
+			t.Errorf("ipString(%s) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// The kernel reports IPv4 peers as plain dotted quads while resolvers may hand
+// out IPv4-mapped IPv6 addresses, neither must look like a change.
+func TestMappedAddressMatchesKernelEndpoint(t *testing.T) {
+	m := &DDNSMonitor{}
+	tests := []struct {
+		kernel   string
+		resolved string
+		changed  bool
+	}{
+		{"192.0.2.1:51820", "::ffff:192.0.2.1", false},
+		{"192.0.2.1:51820", "192.0.2.1", false},
+		{"192.0.2.1:51820", "::ffff:192.0.2.2", true},
+		{"[2001:db8::1]:51820", "::ffff:192.0.2.1", true},
+	}
+
+	for _, tt := range tests {
+		host, port, err := net.SplitHostPort(tt.kernel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := &Config{LastIP: normalizeIP(net.ParseIP(host)), Port: port}
+		result := &Resolution{Chosen: normalizeIP(net.ParseIP(tt.resolved))}
+
+		if got := m.endpointChanged(config, result); got != tt.changed {
+			t.Errorf("endpointChanged(%s, %s) = %v, want %v", tt.kernel, tt.resolved, got, tt.changed)
+		}
+		want := net.JoinHostPort(ipString(result.Chosen), config.EndpointPort())
+		if (want != tt.kernel) != tt.changed {
+			t.Errorf("endpoint for %s is %s, kernel reports %s", tt.resolved, want, tt.kernel)
+		}
+	}
+}
+
+// offlineResolver fails every lookup straight away, so tests never wait on
+// the network.
+func offlineResolver() *HostResolver {
+	return &HostResolver{
+		resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, errors.New("offline")
+			},
+		},
+		family: "ip4",
+	}
+}
+
+func TestSingleInterfaceMonitorsEveryHostnamePeer(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	config := `[Interface]
+PrivateKey = cHJpdmF0ZQ==
+ListenPort = 51820
+
+[Peer]
+PublicKey = YWxwaGE=
+Endpoint = alpha.example.com:51820
+
+[Peer]
+PublicKey = c3RhdGlj
+Endpoint = 192.0.2.1:51820
+
+[Peer]
+PublicKey = YnJhdm8=
+Endpoint = bravo.example.com:51821
+`
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &DDNSMonitor{singleInterface: "wg0", endpointSource: endpointSourceFile, endpointSelection: endpointSelectionLast}
+	m.resolver.Store(offlineResolver())
+	if err := m.parseWireGuardConfig("wg0", configPath); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"YWxwaGE=": "alpha.example.com", "YnJhdm8=": "bravo.example.com"}
+	if len(m.configs) != len(want) {
+		t.Fatalf("monitoring %d endpoints, want %d", len(m.configs), len(want))
+	}
+	for _, c := range m.configs {
+		if want[c.PublicKey] != c.Hostname {
+			t.Errorf("peer %s is monitored as %s, want %s", c.PublicKey, c.Hostname, want[c.PublicKey])
+		}
+		if c.Interface != "wg0" {
+			t.Errorf("peer %s is on interface %s, want wg0", c.PublicKey, c.Interface)
+		}
+	}
+}
+
+func TestParseWireGuardEndpoints(t *testing.T) {
+	type endpoint struct {
+		publicKey string
+		hostname  string
+		port      string
+		staticIP  string
+	}
+	tests := []struct {
+		name   string
+		config string
+		want   []endpoint
+	}{
+		{
+			name: "comments",
+			config: `# Endpoint = ignored.example.com:1
+[Peer] # office
+PublicKey = YQ== # inline
+# Endpoint = old.example.com:51820
+Endpoint = vpn.example.com:51820 # trailing comment
+`,
+			want: []endpoint{{"YQ==", "vpn.example.com", "51820", ""}},
+		},
+		{
+			name: "multiple peers",
+			config: `[Interface]
+ListenPort = 51820
+Endpoint = interface.example.com:51820
+
+[Peer]
+PublicKey = YQ==
+Endpoint = a.example.com:51820
+
+[peer]
+publickey = Yg==
+endpoint = b.example.com:51821
+
+[Peer]
+PublicKey = Yw==
+AllowedIPs = 10.0.0.3/32
+`,
+			want: []endpoint{
+				{"YQ==", "a.example.com", "51820", ""},
+				{"Yg==", "b.example.com", "51821", ""},
+			},
+		},
+		{
+			name: "IPv6 brackets",
+			config: `[Peer]
+PublicKey = YQ==
+Endpoint = [2001:db8::1]:51820 # monitor=v6.example.com
+
+[Peer]
+PublicKey = Yg==
+Endpoint = [2001:db8::2]:51820
+`,
+			want: []endpoint{{"YQ==", "v6.example.com", "51820", "2001:db8::1"}},
+		},
+		{
+			name: "missing ports",
+			config: `[Peer]
+PublicKey = YQ==
+Endpoint = noport.example.com
+
+[Peer]
+PublicKey = Yg==
+Endpoint = [2001:db8::1]
+
+[Peer]
+PublicKey = Yw==
+Endpoint = :51820
+`,
+		},
+		{
+			name: "malformed lines",
+			config: `[Peer
+PublicKey YQ==
+Endpoint vpn.example.com:51820
+= value
+[Peer]
+garbage
+Endpoint =
+PublicKey = Yg==
+Endpoint = ok.example.com:51820
+`,
+			want: []endpoint{{"Yg==", "ok.example.com", "51820", ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs, err := parseWireGuardEndpoints("wg0", strings.NewReader(tt.config), endpointSelectionLast, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(configs) != len(tt.want) {
+				t.Fatalf("parsed %d endpoints, want %d: %+v", len(configs), len(tt.want), configs)
+			}
+			for i, want := range tt.want {
+				got := configs[i]
+				staticIP := ""
+				if got.StaticIP != nil {
+					staticIP = got.StaticIP.String()
+				}
+				if got.PublicKey != want.publicKey || got.Hostname != want.hostname || got.Port != want.port || staticIP != want.staticIP {
+					t.Errorf("endpoint %d = {%s %s %s %s}, want %+v", i, got.PublicKey, got.Hostname, got.Port, staticIP, want)
+				}
+				if got.Interface != "wg0" {
+					t.Errorf("endpoint %d is on interface %s, want wg0", i, got.Interface)
+				}
+			}
+		})
+	}
+}
+
+func TestPersistStateKeepsEndpointsNotLoaded(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	err := saveState(stateFile, &State{
+		Disabled: []EndpointKey{
+			{Interface: "wg0", Hostname: "a.example.com"},
+			{Interface: "wg1", Hostname: "b.example.com"},
+		},
+		PortOverrides: []PortOverride{
+			{Interface: "wg0", Hostname: "a.example.com", Port: "51000"},
+			{Interface: "wg1", Hostname: "b.example.com", Port: "51001"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only wg0 is loaded and its endpoint was enabled again since.
+	m := &DDNSMonitor{stateFile: stateFile}
+	m.configs = []Config{{Interface: "wg0", Hostname: "a.example.com", PortOverride: "51002"}}
+	m.publishConfigs()
+	if err := m.persistState(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := loadState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDisabled := []EndpointKey{{Interface: "wg1", Hostname: "b.example.com"}}
+	if !reflect.DeepEqual(state.Disabled, wantDisabled) {
+		t.Errorf("disabled = %+v, want %+v", state.Disabled, wantDisabled)
+	}
+	wantOverrides := []PortOverride{
+		{Interface: "wg1", Hostname: "b.example.com", Port: "51001"},
+		{Interface: "wg0", Hostname: "a.example.com", Port: "51002"},
+	}
+	if !reflect.DeepEqual(state.PortOverrides, wantOverrides) {
+		t.Errorf("port overrides = %+v, want %+v", state.PortOverrides, wantOverrides)
+	}
+}
+
+// fakeDNS answers A and AAAA queries from records and remembers the types it
+// was asked for.
+type fakeDNS struct {
+	mu      sync.Mutex
+	records map[string][]string
+	rcodes  map[string]dnsmessage.RCode
+	queries []dnsmessage.Type
+}
+
+// fail makes queries for host fail with rcode.
+func (f *fakeDNS) fail(host string, rcode dnsmessage.RCode) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rcodes == nil {
+		f.rcodes = make(map[string]dnsmessage.RCode)
+	}
+	f.rcodes[host+"."] = rcode
+}
+
+func (f *fakeDNS) set(host string, addresses ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.records == nil {
+		f.records = make(map[string][]string)
+	}
+	f.records[host+"."] = addresses
+}
+
+func (f *fakeDNS) asked(qtype dnsmessage.Type) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, q := range f.queries {
+		if q == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeDNS) queried() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.queries)
+}
+
+func (f *fakeDNS) resolver(family string) *HostResolver {
+	return &HostResolver{
+		resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				client, server := net.Pipe()
+				go f.serve(server)
+				return client, nil
+			},
+		},
+		family: family,
+	}
+}
+
+// serve handles DNS-over-TCP framed queries, which the resolver uses for
+// connections that are not packet oriented.
+func (f *fakeDNS) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		buf := make([]byte, int(length[0])<<8|int(length[1]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(buf); err != nil || len(query.Questions) != 1 {
+			return
+		}
+		question := query.Questions[0]
+
+		f.mu.Lock()
+		f.queries = append(f.queries, question.Type)
+		addresses := f.records[question.Name.String()]
+		rcode := f.rcodes[question.Name.String()]
+		f.mu.Unlock()
+
+		response := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true, RecursionAvailable: true, RCode: rcode},
+			Questions: query.Questions,
+		}
+		for _, address := range addresses {
+			ip := net.ParseIP(address)
+			header := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET, TTL: 60}
+			switch {
+			case question.Type == dnsmessage.TypeA && ip.To4() != nil:
+				var a [4]byte
+				copy(a[:], ip.To4())
+				response.Answers = append(response.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: a}})
+			case question.Type == dnsmessage.TypeAAAA && ip.To4() == nil:
+				var aaaa [16]byte
+				copy(aaaa[:], ip)
+				response.Answers = append(response.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: aaaa}})
+			}
+		}
+
+		packed, err := response.Pack()
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write(append([]byte{byte(len(packed) >> 8), byte(len(packed))}, packed...)); err != nil {
+			return
+		}
+	}
+}
+
+func TestAAAAOnlyEndpointChangesAddress(t *testing.T) {
+	dns := &fakeDNS{}
+	dns.set("v6.example.test", "2001:db8::1")
+
+	for _, family := range []string{familyIPv6, familyDual} {
+		t.Run(family, func(t *testing.T) {
+			resolver := dns.resolver(family)
+			m := &DDNSMonitor{}
+			config := &Config{Hostname: "v6.example.test", Port: "51820"}
+
+			result, err := resolver.ResolveFamily(context.Background(), config.Hostname, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.LastIP = result.Chosen
+
+			dns.set("v6.example.test", "2001:db8::2")
+			result, err = resolver.ResolveFamily(context.Background(), config.Hostname, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !m.endpointChanged(config, result) {
+				t.Fatalf("change from %s to %s is not detected", config.LastIP, result.Chosen)
+			}
+			if got, want := net.JoinHostPort(ipString(result.Chosen), config.EndpointPort()), "[2001:db8::2]:51820"; got != want {
+				t.Errorf("endpoint is %s, want %s", got, want)
+			}
+			dns.set("v6.example.test", "2001:db8::1")
+		})
+	}
+}
+
+func TestIPv4ModeDoesNotQueryAAAA(t *testing.T) {
+	dns := &fakeDNS{}
+	dns.set("v6.example.test", "2001:db8::1")
+
+	_, err := dns.resolver(familyIPv4).ResolveFamily(context.Background(), "v6.example.test", "")
+	if err == nil {
+		t.Fatal("AAAA-only hostname resolved in ip4 mode")
+	}
+	if !strings.Contains(err.Error(), "--family ip6") {
+		t.Errorf("error %q does not point at --family ip6", err)
+	}
+	if dns.asked(dnsmessage.TypeAAAA) {
+		t.Error("ip4 mode queried AAAA records")
+	}
+}
+
+func TestMonitorHostnameForIPLiteralEndpoints(t *testing.T) {
+	hostnames, err := parseMonitorHostnames("vpn.example.com, wg1=vpn1.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := `[Peer]
+PublicKey = YQ==
+Endpoint = 203.0.113.10:51820
+
+[Peer]
+PublicKey = Yg==
+Endpoint = 203.0.113.11:51820 # monitor=annotated.example.com
+`
+	tests := []struct {
+		interfaceName string
+		want          []string
+	}{
+		{"wg0", []string{"vpn.example.com", "annotated.example.com"}},
+		{"wg1", []string{"vpn1.example.com", "annotated.example.com"}},
+	}
+
+	for _, tt := range tests {
+		configs, err := parseWireGuardEndpoints(tt.interfaceName, strings.NewReader(config), endpointSelectionLast, hostnames)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, c := range configs {
+			got = append(got, c.Hostname)
+			if c.StaticIP == nil {
+				t.Errorf("%s endpoint %s has no static address", tt.interfaceName, c.Endpoint)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s monitors %v, want %v", tt.interfaceName, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "wg0=", "203.0.113.1", "a.example.com,b.example.com", "wg0=a.example.com,wg0=b.example.com", "bad/name=a.example.com"} {
+		if _, err := parseMonitorHostnames(value); err == nil {
+			t.Errorf("parseMonitorHostnames(%q) succeeded", value)
+		}
+	}
+}
+
+func TestOnceReportsRestartHeldBackByStartupGrace(t *testing.T) {
+	dns := &fakeDNS{}
+	dns.set("vpn.example.test", "192.0.2.2")
+
+	m := &DDNSMonitor{
+		checkInterval: time.Minute,
+		startupGrace:  time.Hour,
+		startedAt:     time.Now(),
+	}
+	m.resolver.Store(dns.resolver(familyIPv4))
+	m.configs = []Config{{
+		Interface: "wg0",
+		Hostname:  "vpn.example.test",
+		Port:      "51820",
+		LastIP:    net.ParseIP("192.0.2.1").To4(),
+	}}
+
+	if code := m.runOnce(context.Background()); code != exitRestartFailed {
+		t.Errorf("runOnce() = %d, want %d", code, exitRestartFailed)
+	}
+}
+
+func TestDNSSECRequiresLoopbackServers(t *testing.T) {
+	tests := []struct {
+		servers []string
+		ok      bool
+	}{
+		{[]string{"127.0.0.1:53"}, true},
+		{[]string{"127.0.0.53:53", "[::1]:5353"}, true},
+		{[]string{"127.0.0.1:53", "192.0.2.53:53"}, false},
+		{[]string{"[2001:db8::53]:53"}, false},
+	}
+
+	for _, tt := range tests {
+		if err := checkDNSSECServers(tt.servers); (err == nil) != tt.ok {
+			t.Errorf("checkDNSSECServers(%v) = %v, want ok %v", tt.servers, err, tt.ok)
+		}
+	}
+}
+
+func TestParseWireGuardEndpointsReadError(t *testing.T) {
+	errRead := errors.New("read failed")
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{
+			name: "truncated endpoint line",
+			config: `[Peer]
+PublicKey = YQ==
+Endpoint = a.example.com:51820
+
+[Peer]
+PublicKey = Yg==
+Endpoint = b.exam`,
+			want: []string{"a.example.com"},
+		},
+		{
+			name: "section missing lines",
+			config: `[Peer]
+PublicKey = YQ==
+Endpoint = a.example.com:51820
+
+[Peer]
+Endpoint = b.example.com:51820
+`,
+			want: []string{"a.example.com"},
+		},
+		{
+			name: "first section",
+			config: `[Peer]
+PublicKey = YQ==
+Endpoint = a.example.com:5`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := io.MultiReader(strings.NewReader(tt.config), errReader{errRead})
+			configs, err := parseWireGuardEndpoints("wg0", r, endpointSelectionLast, nil)
+			if !errors.Is(err, errRead) {
+				t.Fatalf("error = %v, want %v", err, errRead)
+			}
+			var got []string
+			for _, c := range configs {
+				got = append(got, c.Hostname)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsed %v before the error, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransientLookupError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"NXDOMAIN", &net.DNSError{Err: "no such host", Name: "a.example.com", IsNotFound: true}, false},
+		{"SERVFAIL", &net.DNSError{Err: "server misbehaving", Name: "a.example.com", IsTemporary: true}, true},
+		{"timeout", &net.DNSError{Err: "i/o timeout", Name: "a.example.com", IsTimeout: true}, true},
+		{"temporary", &net.DNSError{Err: "temporary failure", Name: "a.example.com", IsTemporary: true}, true},
+		{"other DNS error", &net.DNSError{Err: "unknown", Name: "a.example.com"}, false},
+		{"deadline", context.DeadlineExceeded, true},
+		{"wrapped timeout", &net.OpError{Op: "dial", Net: "udp", Err: timeoutError{}}, true},
+		{"no addresses", errors.New("no addresses found for a.example.com"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isTransientLookupError(tt.err); got != tt.transient {
+			t.Errorf("%s: isTransientLookupError(%v) = %v, want %v", tt.name, tt.err, got, tt.transient)
+		}
+	}
+}
+
+func TestResolutionFailuresReachFailAction(t *testing.T) {
+	tests := []struct {
+		name  string
+		rcode dnsmessage.RCode
+		after int
+	}{
+		{"NXDOMAIN", dnsmessage.RCodeNameError, 3},
+		{"SERVFAIL", dnsmessage.RCodeServerFailure, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dns := &fakeDNS{}
+			dns.fail("vpn.example.test", tt.rcode)
+
+			m := &DDNSMonitor{
+				checkInterval:          time.Minute,
+				failoverAfter:          3,
+				failAction:             failActionNotify,
+				failThreshold:          3,
+				transientFailThreshold: 5,
+			}
+			m.resolver.Store(dns.resolver(familyIPv4))
+			m.configs = []Config{{Interface: "wg0", Hostname: "vpn.example.test", Port: "51820"}}
+
+			for check := 1; check <= tt.after; check++ {
+				m.checkEndpoints(context.Background())
+				config := m.configs[0]
+				if config.ConsecutiveFailures != check {
+					t.Fatalf("check %d counted %d failures", check, config.ConsecutiveFailures)
+				}
+				if taken := check == tt.after; config.FailActionTaken != taken {
+					t.Fatalf("check %d: fail action taken = %v, want %v", check, config.FailActionTaken, taken)
+				}
+			}
+		})
+	}
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	large := strings.Repeat("x", 2*gzipMinSize)
+
+	router := gin.New()
+	router.Use(gzipMiddleware())
+	router.GET("/large", func(c *gin.Context) {
+		c.String(http.StatusOK, large)
+	})
+	router.HEAD("/large", func(c *gin.Context) {
+		c.String(http.StatusOK, large)
+	})
+	router.GET("/header-first", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		c.Writer.WriteHeaderNow()
+		c.Writer.WriteString(large)
+	})
+	router.GET("/no-content", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	router.GET("/not-modified", func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusNotModified)
+	})
+
+	tests := []struct {
+		method  string
+		path    string
+		status  int
+		gzipped bool
+	}{
+		{http.MethodGet, "/large", http.StatusOK, true},
+		{http.MethodGet, "/header-first", http.StatusOK, true},
+		{http.MethodHead, "/large", http.StatusOK, false},
+		{http.MethodGet, "/no-content", http.StatusNoContent, false},
+		{http.MethodGet, "/not-modified", http.StatusNotModified, false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		// Result has the headers as they were when the status was sent.
+		res := rec.Result()
+
+		if res.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, res.StatusCode, tt.status)
+		}
+		gzipped := res.Header.Get("Content-Encoding") == "gzip"
+		if gzipped != tt.gzipped {
+			t.Errorf("%s %s: gzipped = %v, want %v", tt.method, tt.path, gzipped, tt.gzipped)
+		}
+		if !gzipped {
+			if tt.status != http.StatusOK && rec.Body.Len() != 0 {
+				t.Errorf("%s %s: %d byte body on a bodyless response", tt.method, tt.path, rec.Body.Len())
+			}
+			continue
+		}
+		gz, err := gzip.NewReader(res.Body)
+		if err != nil {
+			t.Fatalf("%s %s: %v", tt.method, tt.path, err)
+		}
+		body, err := io.ReadAll(gz)
+		if err != nil || string(body) != large {
+			t.Errorf("%s %s: body does not decompress to the response (%v)", tt.method, tt.path, err)
+		}
+	}
+}
+
+func TestDNSCacheDoesNotShareAddresses(t *testing.T) {
+	cache := newDNSCache(time.Minute)
+	ips := make([]net.IP, 1, 4)
+	ips[0] = net.ParseIP("192.0.2.1").To4()
+	cache.put("ip4", "a.example.com", ips, 0)
+
+	// Appending in place, as dual-stack resolution does, must not reach the
+	// cached entry.
+	_ = append(ips, net.ParseIP("192.0.2.9").To4())
+	ips[0] = net.ParseIP("192.0.2.8").To4()
+
+	got, ok := cache.get("ip4", "a.example.com")
+	if !ok || len(got) != 1 || !got[0].Equal(net.ParseIP("192.0.2.1")) {
+		t.Fatalf("cached %v, want [192.0.2.1]", got)
+	}
+	got[0] = net.ParseIP("192.0.2.7").To4()
+	got = append(got, net.ParseIP("192.0.2.6").To4())
+
+	again, _ := cache.get("ip4", "a.example.com")
+	if len(again) != 1 || !again[0].Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("cached %v after changing a returned slice, want [192.0.2.1]", again)
+	}
+}
+
+func TestReloadKeepsResolverWithoutServersForDNSSEC(t *testing.T) {
+	serversFile := filepath.Join(t.TempDir(), "servers")
+	if err := os.WriteFile(serversFile, []byte("# emptied\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := &HostResolver{resolver: &net.Resolver{}, dnssec: true}
+	m := &DDNSMonitor{dnsServersFile: serversFile, dnssec: true}
+	m.resolver.Store(previous)
+	m.reloadResolver()
+	if m.resolver.Load() != previous {
+		t.Error("resolver replaced although the servers file lists no servers")
+	}
+
+	// Lookups through a resolver without a dialer fail instead of panicking.
+	_, _, err := previous.lookupDirect(context.Background(), "ip4", "a.example.com")
+	if !errors.Is(err, errNoDNSServers) {
+		t.Errorf("lookupDirect() error = %v, want %v", err, errNoDNSServers)
+	}
+}
+
+func TestDefaultInterfaceNamePattern(t *testing.T) {
+	pattern := regexp.MustCompile(defaultInterfaceNamePattern)
+	for _, name := range []string{"wg0", "wg_office.1", "a=b+c", "abcdefghijklmno"} {
+		if !matchesNamePattern(pattern, name) {
+			t.Errorf("default pattern rejects %q", name)
+		}
+	}
+	for _, name := range []string{"", "abcdefghijklmnop", "wg 0", "wg/0", "wg0;"} {
+		if matchesNamePattern(pattern, name) {
+			t.Errorf("default pattern accepts %q", name)
+		}
+	}
+}
+
+// apiRouter serves the endpoint handlers of m without authentication.
+func apiRouter(m *DDNSMonitor) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/interfaces", m.handleListInterfaces)
+	router.POST("/interfaces/:name/disable", m.handleDisableInterface)
+	router.POST("/interfaces/:name/enable", m.handleEnableInterface)
+	router.POST("/interfaces/:name/port", m.handleSetPortOverride)
+	router.POST("/stats/reset", m.handleResetStats)
+	return router
+}
+
+func apiRequest(router *gin.Engine, method, path, body string) int {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec.Code
+}
+
+func TestAPIDoesNotWaitForCheckCycle(t *testing.T) {
+	dns := &fakeDNS{}
+	dns.set("vpn.example.test", "192.0.2.2")
+
+	m := &DDNSMonitor{
+		checkInterval:  time.Minute,
+		coalesceWindow: time.Hour,
+	}
+	m.resolver.Store(dns.resolver(familyIPv4))
+	m.configs = []Config{{
+		Interface: "wg0",
+		Hostname:  "vpn.example.test",
+		Port:      "51820",
+		LastIP:    net.ParseIP("192.0.2.1").To4(),
+	}}
+
+	// The change makes the cycle wait out the coalesce window until ctx is
+	// cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.checkEndpoints(ctx)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for !dns.asked(dnsmessage.TypeA) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	router := apiRouter(m)
+	finished := make(chan int)
+	go func() {
+		finished <- apiRequest(router, http.MethodPost, "/interfaces/wg0/disable", "")
+	}()
+	select {
+	case code := <-finished:
+		if code != http.StatusOK {
+			t.Errorf("disable returned %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("disable blocked by the running check cycle")
+	}
+
+	cancel()
+	<-done
+	if !m.configs[0].Disabled {
+		t.Error("check cycle undid the disable made while it was running")
+	}
+}
+
+// TestConcurrentReloadCheckAndAPI is meant to be run with go test -race.
+func TestConcurrentReloadCheckAndAPI(t *testing.T) {
+	serversFile := filepath.Join(t.TempDir(), "servers")
+	if err := os.WriteFile(serversFile, []byte("127.0.0.1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dns := &fakeDNS{}
+	dns.set("a.example.test", "192.0.2.2")
+	dns.set("b.example.test", "192.0.2.3")
+
+	m := &DDNSMonitor{
+		checkInterval:  time.Minute,
+		dnsServersFile: serversFile,
+		startupGrace:   time.Hour,
+		startedAt:      time.Now(),
+	}
+	m.resolver.Store(dns.resolver(familyIPv4))
+	m.configs = []Config{
+		{Interface: "wg0", Hostname: "a.example.test", Port: "51820", LastIP: net.ParseIP("192.0.2.1").To4()},
+		{Interface: "wg1", Hostname: "b.example.test", Port: "51820", LastIP: net.ParseIP("192.0.2.1").To4()},
+	}
+	m.publishConfigs()
+	router := apiRouter(m)
+
+	var wg sync.WaitGroup
+	run := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				f(i)
+			}
+		}()
+	}
+	run(func(int) { m.checkEndpoints(context.Background()) })
+	run(func(int) { m.reloadResolver() })
+	run(func(i int) {
+		action := "disable"
+		if i%2 == 1 {
+			action = "enable"
+		}
+		apiRequest(router, http.MethodPost, "/interfaces/wg0/"+action, "")
+		apiRequest(router, http.MethodPost, "/interfaces/wg1/port", `{"port": 51821}`)
+		apiRequest(router, http.MethodPost, "/stats/reset", "")
+		apiRequest(router, http.MethodGet, "/interfaces", "")
+	})
+	wg.Wait()
+
+	if m.configs[0].Disabled {
+		t.Error("wg0 is disabled after being enabled last")
+	}
+	if m.configs[1].PortOverride != "51821" {
+		t.Errorf("wg1 port override = %q, want 51821", m.configs[1].PortOverride)
+	}
+}
+
+func TestWaitForConfigFileStopsOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	path := filepath.Join(t.TempDir(), "wg0.conf")
+	start := time.Now()
+	err := waitForConfigFile(ctx, path, time.Hour)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("waitForConfigFile() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("waitForConfigFile() returned after %v", elapsed)
+	}
+}
+
+func TestReleasedRestartDoesNotCountAgainstLimit(t *testing.T) {
+	m := &DDNSMonitor{maxRestartsHour: 2}
+
+	if _, err := m.reserveRestart(); err != nil {
+		t.Fatal(err)
+	}
+	failed, err := m.reserveRestart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.reserveRestart(); !errors.Is(err, errRestartLimitReached) {
+		t.Fatalf("third reserveRestart() error = %v, want %v", err, errRestartLimitReached)
+	}
+
+	m.releaseRestart(failed)
+	if _, err := m.reserveRestart(); err != nil {
+		t.Errorf("reserveRestart() after releasing a failed restart: %v", err)
+	}
+}
+
+func TestScopedKeySeesOnlyItsInterfaces(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	scope, err := parseInterfaceFilter("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &DDNSMonitor{apiKey: "admin", apiKeyScopes: map[string]*InterfaceFilter{"alice": scope}}
+	m.resolver.Store(&HostResolver{})
+	m.configs = []Config{
+		{Interface: "wg0", Hostname: "a.example.test", Port: "51820"},
+		{Interface: "wg1", Hostname: "b.example.test", Port: "51820"},
+	}
+	m.publishConfigs()
+
+	router := gin.New()
+	router.Use(m.authMiddleware())
+	router.GET("/interfaces", m.handleListInterfaces)
+	router.POST("/discover", m.handleDiscover)
+	router.POST("/dns-cache/flush", m.handleFlushDNSCache)
+	router.GET("/logs", m.handleLogs)
+
+	request := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request(http.MethodGet, "/interfaces", "alice")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "wg1") || !strings.Contains(rec.Body.String(), "wg0") {
+		t.Errorf("scoped interfaces listing = %d %s", rec.Code, rec.Body)
+	}
+	if rec := request(http.MethodGet, "/interfaces", "admin"); !strings.Contains(rec.Body.String(), "wg1") {
+		t.Errorf("unscoped interfaces listing misses wg1: %s", rec.Body)
+	}
+
+	for _, tt := range []struct{ method, path string }{
+		{http.MethodPost, "/discover"},
+		{http.MethodPost, "/dns-cache/flush"},
+		{http.MethodGet, "/logs"},
+	} {
+		if rec := request(tt.method, tt.path, "alice"); rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s with a scoped key = %d, want %d", tt.method, tt.path, rec.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestPreviewBypassesCacheAndReportsDeferral(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dns := &fakeDNS{}
+	dns.set("vpn.example.test", "192.0.2.2")
+
+	cache := newDNSCache(time.Hour)
+	resolver := dns.resolver(familyIPv4)
+	resolver.cache = cache
+	m := &DDNSMonitor{
+		dnsCache:     cache,
+		startupGrace: time.Hour,
+		startedAt:    time.Now(),
+	}
+	m.resolver.Store(resolver)
+	m.configs = []Config{{
+		Interface: "wg0",
+		Hostname:  "vpn.example.test",
+		Port:      "51820",
+		LastIP:    net.ParseIP("192.0.2.1").To4(),
+	}}
+	m.publishConfigs()
+
+	router := gin.New()
+	router.GET("/preview", m.handlePreview)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/preview", nil))
+
+	body := rec.Body.String()
+	if !strings.Contains(body, `"action":"deferred"`) || !strings.Contains(body, `"restarts":[]`) {
+		t.Errorf("preview within the startup grace period = %s", body)
+	}
+	if flushed := cache.Flush(); flushed != 0 {
+		t.Errorf("preview left %d lookup(s) in the DNS cache", flushed)
+	}
+}
+
+func TestMaintenanceDuringCoalesceHoldsBackRestarts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dns := &fakeDNS{}
+	dns.set("changed.example.test", "192.0.2.2")
+	dns.set("primary.example.test", "192.0.2.3")
+	dns.fail("gone.example.test", dnsmessage.RCodeNameError)
+
+	m := &DDNSMonitor{
+		checkInterval:          time.Minute,
+		coalesceWindow:         time.Second,
+		failAction:             failActionRestart,
+		failThreshold:          1,
+		transientFailThreshold: 1,
+	}
+	m.resolver.Store(dns.resolver(familyIPv4))
+	m.configs = []Config{
+		// An IP change, a failback from the backup endpoint and a
+		// --fail-action restart, one per interface.
+		{Interface: "wg0", Hostname: "changed.example.test", Port: "51820", LastIP: net.ParseIP("192.0.2.1").To4()},
+		{Interface: "wg1", Hostname: "primary.example.test", Port: "51820", LastIP: net.ParseIP("198.51.100.1").To4(),
+			BackupHostname: "backup.example.test", BackupPort: "51820", UsingBackup: true},
+		{Interface: "wg2", Hostname: "gone.example.test", Port: "51820"},
+	}
+
+	router := gin.New()
+	router.POST("/maintenance", m.handleMaintenance)
+
+	done := make(chan CheckOutcome)
+	go func() {
+		done <- m.checkEndpoints(context.Background())
+	}()
+
+	// The cycle enters the coalesce window once every endpoint was resolved.
+	deadline := time.Now().Add(5 * time.Second)
+	for dns.queried() < len(m.configs) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code := apiRequest(router, http.MethodPost, "/maintenance", `{"enabled": true}`); code != http.StatusOK {
+		t.Fatalf("turning maintenance on returned %d", code)
+	}
+
+	outcome := <-done
+	if !outcome.Skipped || outcome.Failed {
+		t.Errorf("checkEndpoints() = %+v, want every restart skipped", outcome)
+	}
+}